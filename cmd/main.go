@@ -4,6 +4,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/tier2pool/tier2pool/cmd/report"
 	"github.com/tier2pool/tier2pool/cmd/server"
 	"github.com/tier2pool/tier2pool/internal/flag"
 )
@@ -34,6 +35,7 @@ func main() {
 	cmd.PersistentFlags().BoolP("debug", "d", false, "debug mode")
 
 	cmd.AddCommand(server.NewCommand())
+	cmd.AddCommand(report.NewCommand())
 
 	if err := cmd.Execute(); err != nil {
 		logrus.Fatalln(err)
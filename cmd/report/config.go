@@ -0,0 +1,12 @@
+package report
+
+// Config is the part of the server config the report reads
+type Config struct {
+	Ledger *configLedger `yaml:"ledger"`
+}
+
+type configLedger struct {
+	Sink string `yaml:"sink"`
+	Path string `yaml:"path"`
+	DSN  string `yaml:"dsn"`
+}
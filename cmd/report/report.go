@@ -0,0 +1,422 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tier2pool/tier2pool/internal/command"
+	"github.com/tier2pool/tier2pool/internal/ledger"
+	"github.com/tier2pool/tier2pool/internal/router"
+)
+
+const (
+	PeriodDay  = "day"
+	PeriodWeek = "week"
+
+	FormatTable = "table"
+	FormatCSV   = "csv"
+	FormatJSON  = "json"
+
+	GroupWallet = "wallet"
+	GroupWorker = "worker"
+	GroupRoute  = "route"
+
+	dateLayout = "2006-01-02"
+
+	// A share of NiceHash difficulty 1 takes 2^32 hashes on average
+	hashesPerDifficulty = 1 << 32
+)
+
+var _ command.Interface = &Report{}
+
+type Report struct {
+	command *cobra.Command
+	config  Config
+	period  string
+	groups  map[string]bool
+	format  string
+	since   time.Time
+	until   time.Time
+}
+
+// Row is the work of one group in one period
+type Row struct {
+	Period     string  `json:"period"`
+	Wallet     string  `json:"wallet,omitempty"`
+	Worker     string  `json:"worker,omitempty"`
+	Route      string  `json:"route,omitempty"`
+	Shares     int     `json:"shares"`
+	Accepted   int     `json:"accepted"`
+	Rejected   int     `json:"rejected"`
+	Difficulty float64 `json:"difficulty"`
+	// Fee is the ratio of the work of the group that went to the fee routes, it's left out if rows are grouped by route
+	Fee float64 `json:"fee,omitempty"`
+	// Share is the ratio of the work of the group that went to the route, only if rows are grouped by route
+	Share float64 `json:"share,omitempty"`
+	// Hashrate is estimated from the difficulty between the first and the last share, zero if it's unknown
+	Hashrate float64 `json:"hashrate"`
+
+	// The work of the fee routes, and the time covered by the shares
+	feeDifficulty float64
+	feeShares     int
+	first         time.Time
+	last          time.Time
+}
+
+// work sums the shares of a group with every route
+type work struct {
+	difficulty float64
+	shares     int
+}
+
+// ratio is the part of the work, by difficulty unless the token has none like XMR, then by share count
+func (w work) ratio(difficulty float64, shares int) float64 {
+	if w.difficulty > 0 {
+		return difficulty / w.difficulty
+	}
+
+	if w.shares > 0 {
+		return float64(shares) / float64(w.shares)
+	}
+
+	return 0
+}
+
+func (r *Report) Initialize(cmd *cobra.Command) error {
+	r.command = cmd
+
+	// The ledger is read from where the server writes it
+	viper.SetConfigName(r.command.Flag("config").Value.String())
+
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+
+	if err := viper.Unmarshal(&r.config); err != nil {
+		return err
+	}
+
+	if r.config.Ledger == nil {
+		return errors.New("ledger isn't configured")
+	}
+
+	flags := r.command.Flags()
+
+	var err error
+
+	if r.period, err = flags.GetString("period"); err != nil {
+		return err
+	}
+
+	switch r.period {
+	case PeriodDay, PeriodWeek:
+	default:
+		return fmt.Errorf("period %s isn't supported", r.period)
+	}
+
+	if r.format, err = flags.GetString("format"); err != nil {
+		return err
+	}
+
+	switch r.format {
+	case FormatTable, FormatCSV, FormatJSON:
+	default:
+		return fmt.Errorf("format %s isn't supported", r.format)
+	}
+
+	groups, err := flags.GetStringSlice("group")
+	if err != nil {
+		return err
+	}
+
+	r.groups = make(map[string]bool, len(groups))
+
+	for _, group := range groups {
+		switch group {
+		case GroupWallet, GroupWorker, GroupRoute:
+			r.groups[group] = true
+		default:
+			return fmt.Errorf("group %s isn't supported", group)
+		}
+	}
+
+	for name, t := range map[string]*time.Time{"since": &r.since, "until": &r.until} {
+		value, err := flags.GetString(name)
+		if err != nil {
+			return err
+		}
+
+		if value == "" {
+			continue
+		}
+
+		if *t, err = time.Parse(dateLayout, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	// Debug mode
+	if debug, err := r.command.Flags().GetBool("debug"); err == nil && debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	return nil
+}
+
+func (r *Report) Run(cmd *cobra.Command, _ []string) error {
+	if err := r.Initialize(cmd); err != nil {
+		return err
+	}
+
+	rows, err := r.aggregate()
+	if err != nil {
+		return err
+	}
+
+	return r.write(os.Stdout, rows)
+}
+
+// aggregate sums the shares of every period and group
+func (r *Report) aggregate() ([]*Row, error) {
+	rows := make(map[string]*Row)
+	totals := make(map[string]*work)
+
+	option := ledger.Option{
+		Sink: r.config.Ledger.Sink,
+		Path: r.config.Ledger.Path,
+		DSN:  r.config.Ledger.DSN,
+	}
+
+	if err := ledger.Read(option, r.since, r.until, func(share ledger.Share) error {
+		row := Row{
+			Period: r.periodOf(share.Time).Format(dateLayout),
+		}
+
+		if r.groups[GroupWallet] {
+			row.Wallet = share.Wallet
+		}
+
+		if r.groups[GroupWorker] {
+			row.Worker = share.Worker
+		}
+
+		// Without a route in the key, the rows of the routes are the same row
+		base := strings.Join([]string{row.Period, row.Wallet, row.Worker}, "\x00")
+
+		if r.groups[GroupRoute] {
+			row.Route = share.Route
+		}
+
+		key := base + "\x00" + row.Route
+
+		current, ok := rows[key]
+		if !ok {
+			current = &row
+			rows[key] = current
+		}
+
+		current.Shares++
+		current.Difficulty += share.Difficulty
+
+		switch share.Result {
+		case ledger.ResultAccepted:
+			current.Accepted++
+		case ledger.ResultRejected:
+			current.Rejected++
+		}
+
+		if current.first.IsZero() || share.Time.Before(current.first) {
+			current.first = share.Time
+		}
+
+		if share.Time.After(current.last) {
+			current.last = share.Time
+		}
+
+		total, ok := totals[base]
+		if !ok {
+			total = &work{}
+			totals[base] = total
+		}
+
+		total.difficulty += share.Difficulty
+		total.shares++
+
+		if share.Route != router.RouteOrigin {
+			current.feeDifficulty += share.Difficulty
+			current.feeShares++
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	result := make([]*Row, 0, len(rows))
+
+	for _, row := range rows {
+		// Both ratios are parts of the work of the same group with every route
+		total := totals[strings.Join([]string{row.Period, row.Wallet, row.Worker}, "\x00")]
+
+		if r.groups[GroupRoute] {
+			row.Share = total.ratio(row.Difficulty, row.Shares)
+		} else {
+			row.Fee = total.ratio(row.feeDifficulty, row.feeShares)
+		}
+
+		// Shares only tell the time they cover, a period may be partial or clipped by since and until
+		if seconds := row.last.Sub(row.first).Seconds(); seconds > 0 {
+			row.Hashrate = row.Difficulty * hashesPerDifficulty / seconds
+		}
+
+		result = append(result, row)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+
+		for _, pair := range [][2]string{{a.Period, b.Period}, {a.Wallet, b.Wallet}, {a.Worker, b.Worker}, {a.Route, b.Route}} {
+			if pair[0] != pair[1] {
+				return pair[0] < pair[1]
+			}
+		}
+
+		return false
+	})
+
+	return result, nil
+}
+
+// periodOf returns the start of the day or the week, weeks start on Monday
+func (r *Report) periodOf(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	if r.period == PeriodWeek {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+
+	return day
+}
+
+func (r *Report) write(writer io.Writer, rows []*Row) error {
+	if r.format == FormatJSON {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(rows)
+	}
+
+	header := []string{"period"}
+
+	for _, group := range []string{GroupWallet, GroupWorker, GroupRoute} {
+		if r.groups[group] {
+			header = append(header, group)
+		}
+	}
+
+	// The route rows tell their own part of the work instead of the fee
+	ratioName := "fee"
+	if r.groups[GroupRoute] {
+		ratioName = "share"
+	}
+
+	header = append(header, "shares", "accepted", "rejected", "difficulty", ratioName, "hashrate")
+
+	records := [][]string{header}
+
+	for _, row := range rows {
+		record := []string{row.Period}
+
+		if r.groups[GroupWallet] {
+			record = append(record, row.Wallet)
+		}
+
+		if r.groups[GroupWorker] {
+			record = append(record, row.Worker)
+		}
+
+		if r.groups[GroupRoute] {
+			record = append(record, row.Route)
+		}
+
+		ratio := row.Fee
+		if r.groups[GroupRoute] {
+			ratio = row.Share
+		}
+
+		record = append(record,
+			strconv.Itoa(row.Shares),
+			strconv.Itoa(row.Accepted),
+			strconv.Itoa(row.Rejected),
+			strconv.FormatFloat(row.Difficulty, 'f', 0, 64),
+			strconv.FormatFloat(ratio*100, 'f', 2, 64)+"%",
+			formatHashrate(row.Hashrate),
+		)
+
+		records = append(records, record)
+	}
+
+	if r.format == FormatCSV {
+		csvWriter := csv.NewWriter(writer)
+
+		return csvWriter.WriteAll(records)
+	}
+
+	tableWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+
+	for _, record := range records {
+		if _, err := fmt.Fprintln(tableWriter, strings.Join(record, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return tableWriter.Flush()
+}
+
+func formatHashrate(hashrate float64) string {
+	if hashrate == 0 {
+		return "-"
+	}
+
+	units := []string{"H/s", "KH/s", "MH/s", "GH/s", "TH/s", "PH/s"}
+
+	unit := 0
+	for hashrate >= 1000 && unit < len(units)-1 {
+		hashrate /= 1000
+		unit++
+	}
+
+	return strconv.FormatFloat(hashrate, 'f', 2, 64) + " " + units[unit]
+}
+
+func NewCommand() *cobra.Command {
+	report := Report{}
+
+	cmd := cobra.Command{
+		Use:   "report",
+		Short: "Aggregate the shares of the ledger",
+		RunE:  report.Run,
+	}
+
+	cmd.Flags().StringP("config", "c", "server", "config file name")
+	cmd.Flags().String("period", PeriodDay, "aggregate by day or week")
+	cmd.Flags().StringSlice("group", []string{GroupWallet, GroupWorker, GroupRoute}, "group by wallet, worker and route")
+	cmd.Flags().String("format", FormatTable, "output as table, csv or json")
+	cmd.Flags().String("since", "", "first day to report, like 2006-01-02")
+	cmd.Flags().String("until", "", "day to stop before, like 2006-01-02")
+
+	return &cmd
+}
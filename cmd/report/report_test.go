@@ -0,0 +1,136 @@
+package report
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tier2pool/tier2pool/internal/ledger"
+	"github.com/tier2pool/tier2pool/internal/router"
+)
+
+func newReport(t *testing.T, groups []string, shares []ledger.Share) *Report {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "shares.log")
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(file)
+
+	for _, share := range shares {
+		if err := encoder.Encode(share); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report := Report{
+		config: Config{
+			Ledger: &configLedger{Sink: ledger.SinkFile, Path: path},
+		},
+		period: PeriodDay,
+		groups: make(map[string]bool),
+		format: FormatJSON,
+	}
+
+	for _, group := range groups {
+		report.groups[group] = true
+	}
+
+	return &report
+}
+
+func testShares() []ledger.Share {
+	start := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+
+	share := func(wallet, route string, offset time.Duration, difficulty float64) ledger.Share {
+		return ledger.Share{
+			Time:       start.Add(offset),
+			Wallet:     wallet,
+			Route:      route,
+			Difficulty: difficulty,
+			Result:     ledger.ResultAccepted,
+		}
+	}
+
+	return []ledger.Share{
+		share("eth", router.RouteOrigin, 0, 3),
+		share("eth", router.RouteOrigin, time.Minute, 3),
+		share("eth", "fee", time.Second*30, 2),
+		// XMR has no set_difficulty, its shares are counted instead
+		share("xmr", router.RouteOrigin, 0, 0),
+		share("xmr", router.RouteOrigin, time.Second, 0),
+		share("xmr", router.RouteOrigin, time.Second*2, 0),
+		share("xmr", "fee", time.Second*3, 0),
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
+
+func TestAggregate(t *testing.T) {
+	rows, err := newReport(t, []string{GroupWallet}, testShares()).aggregate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+
+	eth, xmr := rows[0], rows[1]
+
+	if !almostEqual(eth.Fee, 0.25) {
+		t.Errorf("eth fee %f, want 0.25", eth.Fee)
+	}
+
+	// The shares cover one minute of the day
+	if want := 8.0 * (1 << 32) / 60; !almostEqual(eth.Hashrate, want) {
+		t.Errorf("eth hashrate %f, want %f", eth.Hashrate, want)
+	}
+
+	if !almostEqual(xmr.Fee, 0.25) {
+		t.Errorf("xmr fee %f, want 0.25", xmr.Fee)
+	}
+
+	if xmr.Hashrate != 0 {
+		t.Errorf("xmr hashrate %f, want unknown", xmr.Hashrate)
+	}
+}
+
+func TestAggregateRoutes(t *testing.T) {
+	rows, err := newReport(t, []string{GroupWallet, GroupRoute}, testShares()).aggregate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{
+		"eth/fee":    0.25,
+		"eth/origin": 0.75,
+		"xmr/fee":    0.25,
+		"xmr/origin": 0.75,
+	}
+
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+
+	for _, row := range rows {
+		key := row.Wallet + "/" + row.Route
+
+		if row.Fee != 0 || !almostEqual(row.Share, want[key]) {
+			t.Errorf("%s has fee %f and share %f, want share %f", key, row.Fee, row.Share, want[key])
+		}
+	}
+}
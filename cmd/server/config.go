@@ -1,36 +1,177 @@
 package server
 
 type Config struct {
-	Server configServer `yaml:"server"`
-	Pool   configPool   `yaml:"pool"`
-	Redis  configRedis  `yaml:"redis"`
+	Server  configServer   `yaml:"server"`
+	Pool    configPool     `yaml:"pool"`
+	Store   configStore    `yaml:"store"`
+	Redis   configRedis    `yaml:"redis"`
+	Metrics *configMetrics `yaml:"metrics"`
+	Status  *configStatus  `yaml:"status"`
+	Ledger  *configLedger  `yaml:"ledger"`
 }
 
 type configServer struct {
-	Address string           `yaml:"address"`
-	Timeout int              `yaml:"timeout"`
-	TLS     *configServerTLS `yaml:"tls"`
+	Address       string                 `yaml:"address"`
+	Timeout       int                    `yaml:"timeout"`
+	IdleTimeout   int                    `yaml:"idletimeout"`
+	MaxLineLength int                    `yaml:"maxlinelength"`
+	TLS           *configServerTLS       `yaml:"tls"`
+	WebSocket     *configServerWebSocket `yaml:"websocket"`
+}
+
+type configServerWebSocket struct {
+	Address string `yaml:"address"`
+	Path    string `yaml:"path"`
 }
 
 type configServerTLS struct {
-	Certificate string `yaml:"certificate"`
-	PrivateKey  string `yaml:"privatekey"`
+	Certificate string                 `yaml:"certificate"`
+	PrivateKey  string                 `yaml:"privatekey"`
+	ACME        *configServerTLSACME   `yaml:"acme"`
+	Client      *configServerTLSClient `yaml:"client"`
+}
+
+type configServerTLSACME struct {
+	Directory string   `yaml:"directory"`
+	Email     string   `yaml:"email"`
+	Domains   []string `yaml:"domains"`
+	Cache     string   `yaml:"cache"`
+	CA        string   `yaml:"ca"`
+}
+
+type configServerTLSClient struct {
+	CA    string                      `yaml:"ca"`
+	Rules []configServerTLSClientRule `yaml:"rules"`
+}
+
+type configServerTLSClientRule struct {
+	Name   string `yaml:"name"`
+	Prefix string `yaml:"prefix"`
 }
 
 type configPool struct {
-	Token   string            `yaml:"token"`
-	Default string            `yaml:"default"`
-	Inject  *configPoolInject `yaml:"inject"`
+	Token     string               `yaml:"token"`
+	Default   string               `yaml:"default"`
+	Inject    []configPoolInject   `yaml:"inject"`
+	Develop   *configPoolDevelop   `yaml:"develop"`
+	Upstreams []configPoolUpstream `yaml:"upstreams"`
+	Proxy     string               `yaml:"proxy"`
+	Dial      *configPoolDial      `yaml:"dial"`
+	Resolve   *configPoolResolve   `yaml:"resolve"`
+	Select    *configPoolSelect    `yaml:"select"`
+	Router    *configPoolRouter    `yaml:"router"`
+	Rules     []configPoolRule     `yaml:"rules"`
+	RuleSync  *configPoolRuleSync  `yaml:"rulesync"`
+	Announce  bool                 `yaml:"announce"`
+}
+
+type configPoolRule struct {
+	Name     string  `yaml:"name"`
+	Wallet   string  `yaml:"wallet"`
+	Worker   string  `yaml:"worker"`
+	CIDR     string  `yaml:"cidr"`
+	Listener string  `yaml:"listener"`
+	Weight   float64 `yaml:"weight"`
+}
+
+type configPoolRuleSync struct {
+	Key      string `yaml:"key"`
+	Interval int    `yaml:"interval"`
+}
+
+type configPoolRouter struct {
+	Name   string `yaml:"name"`
+	Period int    `yaml:"period"`
+}
+
+type configPoolSelect struct {
+	Candidates []string `yaml:"candidates"`
+	Interval   int      `yaml:"interval"`
+}
+
+type configPoolResolve struct {
+	Interval int `yaml:"interval"`
+}
+
+type configPoolDial struct {
+	Timeout       int    `yaml:"timeout"`
+	KeepAlive     int    `yaml:"keepalive"`
+	Nagle         bool   `yaml:"nagle"`
+	Network       string `yaml:"network"`
+	FallbackDelay int    `yaml:"fallbackdelay"`
+}
+
+type configPoolUpstream struct {
+	Pool  string                 `yaml:"pool"`
+	TLS   *configPoolUpstreamTLS `yaml:"tls"`
+	Proxy string                 `yaml:"proxy"`
+}
+
+type configPoolUpstreamTLS struct {
+	CA           string   `yaml:"ca"`
+	ServerName   string   `yaml:"servername"`
+	Certificate  string   `yaml:"certificate"`
+	PrivateKey   string   `yaml:"privatekey"`
+	MinVersion   string   `yaml:"minversion"`
+	Fingerprints []string `yaml:"fingerprints"`
+	Insecure     bool     `yaml:"insecure"`
 }
 
 type configPoolInject struct {
+	Name   string  `yaml:"name"`
 	Pool   string  `yaml:"pool"`
 	Wallet string  `yaml:"wallet"`
 	Weight float64 `yaml:"weight"`
 	Rename string  `yaml:"rename"`
 }
 
+type configPoolDevelop struct {
+	Enable *bool    `yaml:"enable"`
+	Pool   string   `yaml:"pool"`
+	Wallet string   `yaml:"wallet"`
+	Weight *float64 `yaml:"weight"`
+	Rename string   `yaml:"rename"`
+}
+
 type configRedis struct {
-	Address  string `yaml:"address"`
-	Password string `yaml:"password"`
+	Address          string          `yaml:"address"`
+	Addresses        []string        `yaml:"addresses"`
+	Username         string          `yaml:"username"`
+	Password         string          `yaml:"password"`
+	DB               int             `yaml:"db"`
+	MasterName       string          `yaml:"mastername"`
+	SentinelPassword string          `yaml:"sentinelpassword"`
+	Cluster          bool            `yaml:"cluster"`
+	TLS              *configRedisTLS `yaml:"tls"`
+}
+
+type configRedisTLS struct {
+	CA          string `yaml:"ca"`
+	ServerName  string `yaml:"servername"`
+	Certificate string `yaml:"certificate"`
+	PrivateKey  string `yaml:"privatekey"`
+	Insecure    bool   `yaml:"insecure"`
+}
+
+type configMetrics struct {
+	Address string `yaml:"address"`
+}
+
+type configStatus struct {
+	Address string `yaml:"address"`
+}
+
+type configStore struct {
+	Name   string `yaml:"name"`
+	Prefix string `yaml:"prefix"`
+}
+
+type configLedger struct {
+	Sink       string `yaml:"sink"`
+	Path       string `yaml:"path"`
+	DSN        string `yaml:"dsn"`
+	Timescale  bool   `yaml:"timescale"`
+	MaxSize    int64  `yaml:"maxsize"`
+	MaxBackups int    `yaml:"maxbackups"`
+	Buffer     int    `yaml:"buffer"`
 }
@@ -3,7 +3,17 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
@@ -11,15 +21,40 @@ import (
 	"github.com/spf13/viper"
 	"github.com/tier2pool/tier2pool/internal/command"
 	"github.com/tier2pool/tier2pool/internal/extractor"
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+	"github.com/tier2pool/tier2pool/internal/ledger"
+	"github.com/tier2pool/tier2pool/internal/metrics"
+	"github.com/tier2pool/tier2pool/internal/router"
+	"github.com/tier2pool/tier2pool/internal/rule"
+	"github.com/tier2pool/tier2pool/internal/selector"
+	"github.com/tier2pool/tier2pool/internal/store"
+	"github.com/tier2pool/tier2pool/internal/stratum"
+	"github.com/tier2pool/tier2pool/internal/token"
 )
 
+const defaultRuleSyncInterval = time.Second * 30
+
 var _ command.Interface = &Server{}
 
 type Server struct {
 	command     *cobra.Command
 	config      Config
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
+	jobStore    store.JobStore
+	ledger      *ledger.Ledger
+	rules       *rule.Set
+	injects     []extractor.Destination
+	develop     *extractor.Destination
+	dialer      *jsonrpc.Dialer
+	selector    *selector.Selector
 	listener    net.Listener
+	wsListener  net.Listener
+
+	// Sessions are closed on shutdown before the ledger, so the shares they hold are written
+	sessions     map[extractor.Extractor]struct{}
+	sessionGroup sync.WaitGroup
+	sessionLock  sync.Mutex
+	closing      bool
 }
 
 func (s *Server) Initialize(cmd *cobra.Command) error {
@@ -30,7 +65,40 @@ func (s *Server) Initialize(cmd *cobra.Command) error {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
-	if err := s.initializeRedis(); err != nil {
+	// Sessions mine Ethereum by default, initializers and the status read the token
+	if s.config.Pool.Token == "" {
+		s.config.Pool.Token = token.ETH
+	}
+
+	if err := s.initializeStore(); err != nil {
+		return err
+	}
+
+	if err := s.initializeDialer(); err != nil {
+		return err
+	}
+
+	if err := s.initializeSelector(); err != nil {
+		return err
+	}
+
+	if err := s.initializeRouter(); err != nil {
+		return err
+	}
+
+	if err := s.initializeLedger(); err != nil {
+		return err
+	}
+
+	if err := s.initializeDevelop(); err != nil {
+		return err
+	}
+
+	if err := s.initializeInjects(); err != nil {
+		return err
+	}
+
+	if err := s.initializeRules(); err != nil {
 		return err
 	}
 
@@ -39,99 +107,654 @@ func (s *Server) Initialize(cmd *cobra.Command) error {
 	return nil
 }
 
+func (s *Server) initializeStore() error {
+	if s.config.Store.Name == "" {
+		s.config.Store.Name = store.NameMemory
+	}
+
+	switch s.config.Store.Name {
+	case store.NameMemory:
+		s.jobStore = store.NewMemoryJobStore()
+	case store.NameRedis:
+		// Keys are namespaced by token and session, so that several instances and tokens can share one Redis
+		// without reading each other's jobs
+		if err := s.initializeRedis(); err != nil {
+			return err
+		}
+
+		s.jobStore = store.NewRedisJobStore(s.redisClient)
+	default:
+		return fmt.Errorf("%s store isn't supported", s.config.Store.Name)
+	}
+
+	logrus.Infof("jobs are stored in %s", s.config.Store.Name)
+
+	return nil
+}
+
+// initializeRedis connects once, the job store and the rule sync may share the client
 func (s *Server) initializeRedis() error {
-	ip, err := net.ResolveTCPAddr("tcp", s.config.Redis.Address)
-	if err != nil {
+	if s.redisClient != nil {
+		return nil
+	}
+
+	addresses := s.config.Redis.Addresses
+	if s.config.Redis.Address != "" {
+		addresses = append([]string{s.config.Redis.Address}, addresses...)
+	}
+
+	if len(addresses) == 0 {
+		return errors.New("redis requires at least one address")
+	}
+
+	options := redis.UniversalOptions{
+		Addrs:            addresses,
+		Username:         s.config.Redis.Username,
+		Password:         s.config.Redis.Password,
+		DB:               s.config.Redis.DB,
+		MasterName:       s.config.Redis.MasterName,
+		SentinelPassword: s.config.Redis.SentinelPassword,
+	}
+
+	if s.config.Redis.TLS != nil {
+		tlsConfig, err := jsonrpc.NewTLSConfig(jsonrpc.TLSOption{
+			CA:          s.config.Redis.TLS.CA,
+			ServerName:  s.config.Redis.TLS.ServerName,
+			Certificate: s.config.Redis.TLS.Certificate,
+			PrivateKey:  s.config.Redis.TLS.PrivateKey,
+			Insecure:    s.config.Redis.TLS.Insecure,
+		})
+		if err != nil {
+			return fmt.Errorf("redis: %w", err)
+		}
+
+		// Nodes are dialed by address, their certificates are verified against the first host unless told otherwise
+		if tlsConfig.ServerName == "" {
+			if host, _, err := net.SplitHostPort(addresses[0]); err == nil {
+				tlsConfig.ServerName = host
+			}
+		}
+
+		options.TLSConfig = tlsConfig
+	} else if s.config.Redis.Password == "" {
+		// Redis is exposed to the public network without protection
+		for _, address := range addresses {
+			if ip, err := net.ResolveTCPAddr("tcp", address); err == nil && !(ip.IP.IsPrivate() || ip.IP.IsLoopback()) {
+				logrus.Warn("redis has no password and is exposed to the public network")
+
+				break
+			}
+		}
+	}
+
+	switch {
+	case s.config.Redis.Cluster:
+		// A single seed address is enough to discover a cluster, the universal client would take it for a node
+		if s.config.Redis.DB != 0 {
+			return errors.New("redis cluster only has db 0")
+		}
+
+		s.redisClient = redis.NewClusterClient(options.Cluster())
+	default:
+		s.redisClient = redis.NewUniversalClient(&options)
+	}
+
+	// The tunnel keeps forwarding origin traffic while redis is unavailable, fee jobs resume once it's back
+	if err := s.redisClient.Ping(context.Background()).Err(); err != nil {
+		logrus.Warnf("redis is unavailable, fee jobs are paused until it recovers: %s", err)
+
+		return nil
+	}
+
+	logrus.Info("connected to redis")
+
+	return nil
+}
+
+func (s *Server) initializeDialer() error {
+	s.dialer = &jsonrpc.Dialer{
+		Option: jsonrpc.Option{
+			Proxy: s.config.Pool.Proxy,
+		},
+		Options: make(map[string]jsonrpc.Option),
+	}
+
+	if dial := s.config.Pool.Dial; dial != nil {
+		switch dial.Network {
+		case "", "tcp", "tcp4", "tcp6":
+		default:
+			return fmt.Errorf("network %s isn't supported", dial.Network)
+		}
+
+		s.dialer.Option.Timeout = time.Second * time.Duration(dial.Timeout)
+		s.dialer.Option.KeepAlive = time.Second * time.Duration(dial.KeepAlive)
+		s.dialer.Option.Nagle = dial.Nagle
+		s.dialer.Option.Network = dial.Network
+		s.dialer.Option.FallbackDelay = time.Millisecond * time.Duration(dial.FallbackDelay)
+	}
+
+	// Pools publishing many regional addresses, the fastest reachable one is preferred
+	if s.config.Pool.Resolve != nil {
+		s.dialer.Option.Resolver = &jsonrpc.Resolver{
+			Interval: time.Second * time.Duration(s.config.Pool.Resolve.Interval),
+		}
+	}
+
+	for _, upstream := range s.config.Pool.Upstreams {
+		option := s.dialer.Option
+
+		// Some pools are reachable directly, others only through the egress proxy
+		if upstream.Proxy != "" {
+			option.Proxy = upstream.Proxy
+		}
+
+		if upstream.TLS != nil {
+			tlsConfig, err := jsonrpc.NewTLSConfig(jsonrpc.TLSOption{
+				CA:           upstream.TLS.CA,
+				ServerName:   upstream.TLS.ServerName,
+				Certificate:  upstream.TLS.Certificate,
+				PrivateKey:   upstream.TLS.PrivateKey,
+				MinVersion:   upstream.TLS.MinVersion,
+				Fingerprints: upstream.TLS.Fingerprints,
+				Insecure:     upstream.TLS.Insecure,
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", upstream.Pool, err)
+			}
+
+			// Verification is only skipped when explicitly asked, make it loud
+			if upstream.TLS.Insecure && len(upstream.TLS.Fingerprints) == 0 {
+				logrus.Warnf("certificate of %s will not be verified", upstream.Pool)
+			}
+
+			option.TLS = tlsConfig
+		}
+
+		s.dialer.Options[upstream.Pool] = option
+	}
+
+	return nil
+}
+
+func (s *Server) initializeSelector() error {
+	if s.config.Pool.Select == nil {
+		return nil
+	}
+
+	if len(s.config.Pool.Select.Candidates) == 0 {
+		return errors.New("pool selection requires at least one candidate")
+	}
+
+	// Monero pools only answer a login with a wallet, they are only dialed
+	var probe *selector.Probe
+	if s.config.Pool.Token != token.XMR {
+		probe = &selector.Probe{
+			Method: stratum.MethodNiceHashSubscribe,
+			Params: stratum.NiceHashSubscribeParams{"tier2pool", stratum.NiceHashProtocol},
+		}
+	}
+
+	s.selector = selector.New(
+		s.dialer,
+		s.config.Pool.Select.Candidates,
+		time.Second*time.Duration(s.config.Pool.Select.Interval),
+		probe,
+	)
+
+	logrus.Infof("pool is selected from %d candidates by latency", len(s.config.Pool.Select.Candidates))
+
+	return nil
+}
+
+// initializeRouter rejects an unknown router at startup instead of failing every session
+func (s *Server) initializeRouter() error {
+	if s.config.Pool.Router == nil {
+		return nil
+	}
+
+	if _, err := router.New(router.Option{
+		Name:   s.config.Pool.Router.Name,
+		Period: time.Second * time.Duration(s.config.Pool.Router.Period),
+	}); err != nil {
 		return err
 	}
 
-	// Redis is exposed to the public network without protection
-	if s.config.Redis.Password == "" && !(ip.IP.IsPrivate() || ip.IP.IsLoopback()) {
-		logrus.Warn("redis has no password and is exposed to the public network")
+	logrus.Infof("jobs are routed by %s", s.config.Pool.Router.Name)
+
+	return nil
+}
+
+func (s *Server) initializeLedger() error {
+	if s.config.Ledger == nil {
+		return nil
 	}
 
-	s.redisClient = redis.NewClient(&redis.Options{
-		Addr:     s.config.Redis.Address,
-		Password: s.config.Redis.Password,
+	var err error
+
+	s.ledger, err = ledger.New(ledger.Option{
+		Sink:       s.config.Ledger.Sink,
+		Path:       s.config.Ledger.Path,
+		DSN:        s.config.Ledger.DSN,
+		Timescale:  s.config.Ledger.Timescale,
+		MaxSize:    s.config.Ledger.MaxSize * 1024 * 1024,
+		MaxBackups: s.config.Ledger.MaxBackups,
+		BufferSize: s.config.Ledger.Buffer,
 	})
+	if err != nil {
+		return err
+	}
 
-	// Check if the connection is successful
-	if err := s.redisClient.Ping(context.Background()).Err(); err != nil {
+	logrus.Infof("shares are recorded to the %s ledger", s.config.Ledger.Sink)
+
+	return nil
+}
+
+// initializeDevelop resolves the sponsor's destination, the built-in one of the token fills the fields left empty,
+// a token the sponsor doesn't mine only disables the develop fee
+func (s *Server) initializeDevelop() error {
+	option := s.config.Pool.Develop
+	if option == nil {
+		option = &configPoolDevelop{}
+	}
+
+	if option.Enable != nil && !*option.Enable {
+		logrus.Info("develop fee is disabled")
+
+		return nil
+	}
+
+	develop, err := extractor.DefaultDevelop(s.config.Pool.Token)
+	if err != nil && (option.Pool == "" || option.Wallet == "") {
+		logrus.Warnf("develop fee is disabled: %s", err)
+
+		return nil
+	}
+
+	if option.Pool != "" {
+		develop.Pool = option.Pool
+	}
+
+	if option.Wallet != "" {
+		develop.Wallet = option.Wallet
+	}
+
+	if option.Weight != nil {
+		develop.Weight = *option.Weight
+	}
+
+	if option.Rename != "" {
+		develop.Worker = option.Rename
+	}
+
+	if develop.Weight < 0 || develop.Weight >= 1 {
+		return fmt.Errorf("develop weight %f is out of range [0, 1)", develop.Weight)
+	}
+
+	if develop.Weight == 0 {
+		logrus.Info("develop fee is disabled")
+
+		return nil
+	}
+
+	s.develop = &develop
+
+	logrus.Infof("develop fee of %.2f%% goes to wallet %s as worker %s on %s",
+		develop.Weight*100, develop.Wallet, develop.Worker, develop.Pool)
+
+	return nil
+}
+
+func (s *Server) initializeInjects() error {
+	names := map[string]bool{
+		router.RouteOrigin:  true,
+		router.RouteDevelop: true,
+	}
+
+	total := 0.0
+
+	for i, inject := range s.config.Pool.Inject {
+		if inject.Pool == "" {
+			return errors.New("inject destination requires a pool")
+		}
+
+		// A single destination keeps the plain name, the others are named after their position
+		if inject.Name == "" {
+			inject.Name = router.RouteInject
+
+			if i > 0 {
+				inject.Name = fmt.Sprintf("%s-%d", router.RouteInject, i+1)
+			}
+		}
+
+		if names[inject.Name] {
+			return fmt.Errorf("inject destination name %s is taken", inject.Name)
+		}
+
+		names[inject.Name] = true
+
+		total += inject.Weight
+
+		s.injects = append(s.injects, extractor.Destination{
+			Route:  inject.Name,
+			Pool:   inject.Pool,
+			Wallet: inject.Wallet,
+			Worker: inject.Rename,
+			Weight: inject.Weight,
+		})
+	}
+
+	if total > 1 {
+		return fmt.Errorf("total inject weight %f is greater than 1", total)
+	}
+
+	// The develop fee is taken first, the injects get at most what it leaves
+	if s.develop != nil && total+s.develop.Weight > 1 {
+		logrus.Warnf("total inject weight %f is capped at %f by the develop fee", total, 1-s.develop.Weight)
+	}
+
+	if len(s.injects) > 0 {
+		logrus.Infof("fee of %.2f%% is injected into %d destinations", total*100, len(s.injects))
+	}
+
+	return nil
+}
+
+func (s *Server) initializeRules() error {
+	rules := make([]rule.Rule, 0, len(s.config.Pool.Rules))
+
+	for _, r := range s.config.Pool.Rules {
+		rules = append(rules, rule.Rule{
+			Name:     r.Name,
+			Wallet:   r.Wallet,
+			Worker:   r.Worker,
+			CIDR:     r.CIDR,
+			Listener: r.Listener,
+			Weight:   r.Weight,
+		})
+	}
+
+	// Rules set the total fee, miners matching no rule pay the weights of the develop and all inject destinations
+	var weight float64
+	for _, inject := range s.injects {
+		weight += inject.Weight
+	}
+
+	if s.develop != nil {
+		weight += s.develop.Weight
+	}
+
+	weight = math.Min(weight, 1)
+
+	var err error
+
+	if s.rules, err = rule.NewSet(rules, weight); err != nil {
 		return err
 	}
 
-	logrus.Info("connected to redis")
+	if s.config.Pool.RuleSync != nil {
+		if s.config.Pool.RuleSync.Key == "" {
+			return errors.New("rule sync requires a key")
+		}
+
+		if err := s.initializeRedis(); err != nil {
+			return err
+		}
+	}
+
+	logrus.Infof("%d fee rules are loaded", len(rules))
 
 	return nil
 }
 
+// syncRules replaces the rules with the JSON list of the redis key whenever it changes,
+// the rules of the config are kept while the key doesn't exist
+func (s *Server) syncRules(ctx context.Context) {
+	interval := time.Second * time.Duration(s.config.Pool.RuleSync.Interval)
+	if interval == 0 {
+		interval = defaultRuleSyncInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last string
+
+	for {
+		data, err := s.redisClient.Get(ctx, s.config.Pool.RuleSync.Key).Result()
+
+		switch {
+		case errors.Is(err, redis.Nil):
+		case err != nil:
+			logrus.Warnf("failed to sync fee rules: %s", err)
+		case data != last:
+			if err := s.rules.ReplaceJSON([]byte(data)); err != nil {
+				logrus.Errorf("fee rules of %s are invalid: %s", s.config.Pool.RuleSync.Key, err)
+			} else {
+				logrus.Infof("fee rules are replaced from %s", s.config.Pool.RuleSync.Key)
+			}
+
+			last = data
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func (s *Server) Run(cmd *cobra.Command, _ []string) (err error) {
 	if err = s.Initialize(cmd); err != nil {
 		return err
 	}
 
+	var tlsConfig *tls.Config
+
 	// Nginx or other gateways are flexible options
-	if s.config.Server.TLS == nil {
-		if s.listener, err = net.Listen("tcp", s.config.Server.Address); err != nil {
+	if s.config.Server.TLS != nil {
+		if tlsConfig, err = s.initializeTLS(); err != nil {
 			return err
 		}
-	} else {
-		certificate, err := tls.LoadX509KeyPair(
-			s.config.Server.TLS.Certificate,
-			s.config.Server.TLS.PrivateKey,
-		)
+	}
+
+	if s.listener, err = listen(s.config.Server.Address, tlsConfig); err != nil {
+		return err
+	}
+
+	// Shutting down stops accepting miners, closes the sessions and lets the ledger write what they left
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ledgerCtx, stopLedger := context.WithCancel(context.Background())
+	defer stopLedger()
+
+	if s.selector != nil {
+		go s.selector.Run(ctx)
+	}
+
+	if s.ledger != nil {
+		go s.ledger.Run(ledgerCtx)
+	}
+
+	if s.config.Pool.RuleSync != nil {
+		go s.syncRules(ctx)
+	}
+
+	if s.config.Metrics != nil {
+		go s.serveMetrics()
+	}
+
+	if s.config.Status != nil {
+		go s.serveStatus()
+	}
+
+	// Browser-based or firewalled clients can only speak WebSocket
+	if s.config.Server.WebSocket != nil {
+		listener, err := listen(s.config.Server.WebSocket.Address, webSocketTLS(tlsConfig))
 		if err != nil {
 			return err
 		}
 
-		s.listener, err = tls.Listen("tcp", s.config.Server.Address, &tls.Config{
-			Certificates: []tls.Certificate{
-				certificate,
-			},
-		})
-		if err != nil {
-			return err
+		s.wsListener = jsonrpc.ListenWebSocket(listener, s.config.Server.WebSocket.Path)
+
+		logrus.Infof("websocket is listening on %s", s.config.Server.WebSocket.Address)
+
+		go s.serve(s.wsListener, s.config.Server.WebSocket.Address)
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		logrus.Info("shutting down")
+
+		_ = s.listener.Close()
+
+		if s.wsListener != nil {
+			_ = s.wsListener.Close()
 		}
+	}()
+
+	s.serve(s.listener, s.config.Server.Address)
+
+	s.closeSessions()
+
+	if s.ledger != nil {
+		stopLedger()
+
+		<-s.ledger.Done()
+	}
+
+	return nil
+}
+
+// addSession tracks the session until it ends, it's refused once the server is shutting down
+func (s *Server) addSession(session extractor.Extractor) bool {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+
+	if s.closing {
+		return false
 	}
 
-	var conn net.Conn
+	if s.sessions == nil {
+		s.sessions = make(map[extractor.Extractor]struct{})
+	}
+
+	s.sessions[session] = struct{}{}
+	s.sessionGroup.Add(1)
+
+	return true
+}
+
+func (s *Server) removeSession(session extractor.Extractor) {
+	s.sessionLock.Lock()
+	delete(s.sessions, session)
+	s.sessionLock.Unlock()
+
+	s.sessionGroup.Done()
+}
+
+// closeSessions closes every session and waits until they recorded their unanswered shares
+func (s *Server) closeSessions() {
+	s.sessionLock.Lock()
+
+	s.closing = true
+
+	sessions := make([]extractor.Extractor, 0, len(s.sessions))
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+
+	s.sessionLock.Unlock()
+
+	for _, session := range sessions {
+		session.Close()
+	}
+
+	s.sessionGroup.Wait()
+}
+
+// serve accepts the connections of the listener, the address tells the listeners apart in fee rules
+func (s *Server) serve(listener net.Listener, address string) {
 	for {
-		conn, err = s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
 			logrus.Error(err)
 
 			continue
 		}
 
-		go s.handle(conn)
+		go s.handle(conn, address)
 	}
 }
 
-func (s *Server) handle(localConn net.Conn) {
+func (s *Server) handle(localConn net.Conn, listener string) {
 	logrus.Infof("new connection from %s", localConn.RemoteAddr())
 
 	defer logrus.Infof("%s is disconnected", localConn.RemoteAddr())
 
+	prefixes, err := s.authorizeCertificate(localConn)
+	if err != nil {
+		logrus.Error(err)
+
+		_ = localConn.Close()
+
+		return
+	}
+
+	remoteURL := s.config.Pool.Default
+	if s.selector != nil {
+		remoteURL = s.selector.Select()
+	}
+
 	extractorConfig := extractor.Option{
-		Token:   s.config.Pool.Token,
-		Timeout: s.config.Server.Timeout,
+		Token:         s.config.Pool.Token,
+		Timeout:       s.config.Server.Timeout,
+		IdleTimeout:   s.config.Server.IdleTimeout,
+		MaxLineLength: s.config.Server.MaxLineLength,
+		Dialer:        s.dialer,
+		Prefixes:      prefixes,
+		KeyPrefix:     s.config.Store.Prefix,
+		Ledger:        s.ledger,
+		Rules:         s.rules,
+		Listener:      listener,
+		Announce:      s.config.Pool.Announce,
+	}
+
+	if s.config.Pool.Router != nil {
+		extractorConfig.Router = s.config.Pool.Router.Name
+		extractorConfig.RouterPeriod = s.config.Pool.Router.Period
 	}
 
 	// Users may choose to use only for forwarding
-	if s.config.Pool.Inject != nil {
-		extractorConfig.Pool = s.config.Pool.Inject.Pool
-		extractorConfig.Wallet = s.config.Pool.Inject.Wallet
-		extractorConfig.Weight = s.config.Pool.Inject.Weight
-		extractorConfig.Rename = s.config.Pool.Inject.Rename
+	extractorConfig.Injects = s.injects
+	extractorConfig.Develop = s.develop
+
+	// Keep the field nil instead of a typed nil, the extractor checks it
+	if s.selector != nil {
+		extractorConfig.Observer = s.selector
 	}
 
-	conn, err := extractor.New(s.redisClient, localConn, s.config.Pool.Default, extractorConfig)
+	conn, err := extractor.New(s.jobStore, localConn, remoteURL, extractorConfig)
 	if err != nil {
 		logrus.Error(err)
 
 		return
 	}
 
+	if !s.addSession(conn) {
+		conn.Close()
+
+		return
+	}
+
+	defer s.removeSession(conn)
+
 	if err := conn.Inject(); err != nil {
 		logrus.Error(err)
 
@@ -139,6 +762,130 @@ func (s *Server) handle(localConn net.Conn) {
 	}
 }
 
+func (s *Server) serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/fees", s.serveFees)
+
+	server := http.Server{
+		Addr:              s.config.Metrics.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: s.timeout(),
+	}
+
+	logrus.Infof("metrics is listening on %s", s.config.Metrics.Address)
+
+	if err := server.ListenAndServe(); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// serveFees reports the target and achieved fee ratio of all sessions
+func (s *Server) serveFees(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(writer).Encode(extractor.Report()); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func (s *Server) serveStatus() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.serveFeeRules)
+
+	server := http.Server{
+		Addr:              s.config.Status.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: s.timeout(),
+	}
+
+	logrus.Infof("status is listening on %s", s.config.Status.Address)
+
+	if err := server.ListenAndServe(); err != nil {
+		logrus.Error(err)
+	}
+}
+
+type status struct {
+	Token   string              `json:"token"`
+	Develop *statusDestination  `json:"develop"`
+	Injects []statusDestination `json:"injects"`
+	Rules   []statusRule        `json:"rules"`
+}
+
+type statusDestination struct {
+	Name   string  `json:"name"`
+	Pool   string  `json:"pool"`
+	Wallet string  `json:"wallet"`
+	Weight float64 `json:"weight"`
+}
+
+// statusRule leaves out the wallet and network of a rule, they belong to the customers
+type statusRule struct {
+	Name     string `json:"name"`
+	Worker   string `json:"worker,omitempty"`
+	Listener string `json:"listener,omitempty"`
+	// Weight is the total fee of the miners matching the rule, the develop fee included
+	Weight float64 `json:"weight"`
+}
+
+func newStatusDestination(destination extractor.Destination) statusDestination {
+	return statusDestination{
+		Name:   destination.Route,
+		Pool:   destination.Pool,
+		Wallet: destination.Wallet,
+		Weight: destination.Weight,
+	}
+}
+
+// serveFeeRules publishes where the fee goes and the current fee rules, rules synced from redis included,
+// the rule weight is the total fee, the develop fee is taken from it first and the injects share the rest by their weights
+func (s *Server) serveFeeRules(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	result := status{
+		Token:   s.config.Pool.Token,
+		Injects: make([]statusDestination, 0, len(s.injects)),
+		Rules:   make([]statusRule, 0),
+	}
+
+	if s.develop != nil {
+		develop := newStatusDestination(*s.develop)
+		result.Develop = &develop
+	}
+
+	for _, inject := range s.injects {
+		result.Injects = append(result.Injects, newStatusDestination(inject))
+	}
+
+	for _, r := range s.rules.Rules() {
+		result.Rules = append(result.Rules, statusRule{
+			Name:     r.Name,
+			Worker:   r.Worker,
+			Listener: r.Listener,
+			Weight:   r.Weight,
+		})
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(writer).Encode(result); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func listen(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	if tlsConfig == nil {
+		return net.Listen("tcp", address)
+	}
+
+	return tls.Listen("tcp", address, tlsConfig)
+}
+
 func NewCommand() *cobra.Command {
 	srv := Server{}
 
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	ErrCertificateNotAllowed = errors.New("client certificate is not allowed")
+)
+
+func (s *Server) initializeTLS() (*tls.Config, error) {
+	tlsConfig := tls.Config{}
+
+	if s.config.Server.TLS.ACME != nil {
+		manager, err := s.initializeACME()
+		if err != nil {
+			return nil, err
+		}
+
+		// Only the TLS-ALPN-01 challenge is supported, miners don't negotiate any protocol
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = []string{acme.ALPNProto}
+	} else {
+		loader, err := newCertificateLoader(
+			s.config.Server.TLS.Certificate,
+			s.config.Server.TLS.PrivateKey,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.GetCertificate = loader.GetCertificate
+	}
+
+	// Only rigs holding a certificate issued by our own CA can use the tunnel
+	if s.config.Server.TLS.Client != nil {
+		data, err := os.ReadFile(s.config.Server.TLS.Client.CA)
+		if err != nil {
+			return nil, err
+		}
+
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", s.config.Server.TLS.Client.CA)
+		}
+
+		tlsConfig.ClientCAs = certPool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		if s.config.Server.TLS.ACME != nil {
+			allowChallenge(&tlsConfig)
+		}
+	}
+
+	return &tlsConfig, nil
+}
+
+// allowChallenge lets the ACME validator, which has no client certificate, complete the TLS-ALPN-01 handshake,
+// the connection is still refused by authorizeCertificate if it's used for anything else
+func allowChallenge(tlsConfig *tls.Config) {
+	challenge := tlsConfig.Clone()
+	challenge.ClientAuth = tls.NoClientCert
+	challenge.ClientCAs = nil
+	challenge.NextProtos = []string{acme.ALPNProto}
+
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		for _, proto := range hello.SupportedProtos {
+			if proto == acme.ALPNProto {
+				return challenge, nil
+			}
+		}
+
+		return nil, nil
+	}
+}
+
+func (s *Server) initializeACME() (*autocert.Manager, error) {
+	config := s.config.Server.TLS.ACME
+
+	if len(config.Domains) == 0 {
+		return nil, errors.New("acme requires at least one domain")
+	}
+
+	client := acme.Client{
+		DirectoryURL: config.Directory,
+	}
+
+	// A local ACME test server like Pebble is served with its own root certificate
+	if config.CA != "" {
+		data, err := os.ReadFile(config.CA)
+		if err != nil {
+			return nil, err
+		}
+
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", config.CA)
+		}
+
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					RootCAs: certPool,
+				},
+			},
+		}
+	}
+
+	manager := autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.Domains...),
+		Email:      config.Email,
+		Client:     &client,
+	}
+
+	if config.Cache != "" {
+		manager.Cache = autocert.DirCache(config.Cache)
+	} else {
+		logrus.Warn("acme has no cache directory, certificates will be issued again after restart")
+	}
+
+	logrus.Infof("acme is enabled for %s", strings.Join(config.Domains, ", "))
+
+	return &manager, nil
+}
+
+// authorizeCertificate returns the wallet or worker prefixes allowed for the client certificate,
+// a nil slice means that mutual TLS is disabled and any wallet can be used
+func (s *Server) authorizeCertificate(conn net.Conn) ([]string, error) {
+	if s.config.Server.TLS == nil || s.config.Server.TLS.Client == nil {
+		return nil, nil
+	}
+
+	var state tls.ConnectionState
+
+	switch conn := conn.(type) {
+	case *tls.Conn:
+		// The handshake is lazy, complete it to get the verified peer certificates
+		_ = conn.SetDeadline(time.Now().Add(s.timeout()))
+
+		if err := conn.Handshake(); err != nil {
+			return nil, err
+		}
+
+		_ = conn.SetDeadline(time.Time{})
+
+		state = conn.ConnectionState()
+	case interface{ ConnectionState() tls.ConnectionState }:
+		// WebSocket connections are already upgraded over TLS
+		state = conn.ConnectionState()
+	default:
+		return nil, ErrCertificateNotAllowed
+	}
+
+	certificates := state.PeerCertificates
+	if len(certificates) == 0 {
+		return nil, ErrCertificateNotAllowed
+	}
+
+	names := certificateNames(certificates[0])
+
+	prefixes := make([]string, 0)
+
+	for _, rule := range s.config.Server.TLS.Client.Rules {
+		for _, name := range names {
+			if rule.Name == name {
+				prefixes = append(prefixes, rule.Prefix)
+
+				break
+			}
+		}
+	}
+
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCertificateNotAllowed, certificates[0].Subject.CommonName)
+	}
+
+	return prefixes, nil
+}
+
+func (s *Server) timeout() time.Duration {
+	// Default 3 seconds timeout
+	if s.config.Server.Timeout == 0 {
+		return time.Second * 3
+	}
+
+	return time.Second * time.Duration(s.config.Server.Timeout)
+}
+
+// certificateNames collects the common name and all subject alternative names of the certificate
+func certificateNames(certificate *x509.Certificate) []string {
+	names := make([]string, 0, 1+len(certificate.DNSNames)+len(certificate.EmailAddresses)+len(certificate.URIs))
+
+	if certificate.Subject.CommonName != "" {
+		names = append(names, certificate.Subject.CommonName)
+	}
+
+	names = append(names, certificate.DNSNames...)
+	names = append(names, certificate.EmailAddresses...)
+
+	for _, uri := range certificate.URIs {
+		names = append(names, uri.String())
+	}
+
+	return names
+}
+
+// certificateReloadInterval is the minimum interval between two checks of the certificate files
+const certificateReloadInterval = time.Minute
+
+// certificateLoader reloads the certificate when its files change,
+// so certificates renewed by certbot are picked up without restart
+type certificateLoader struct {
+	certificatePath string
+	privateKeyPath  string
+	certificate     *tls.Certificate
+	modTime         time.Time
+	checkedAt       time.Time
+	locker          sync.Mutex
+}
+
+func (l *certificateLoader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+
+	if time.Since(l.checkedAt) < certificateReloadInterval {
+		return l.certificate, nil
+	}
+
+	l.checkedAt = time.Now()
+
+	modTime, err := l.lastModified()
+	if err != nil {
+		logrus.Errorf("failed to check certificate: %s", err)
+
+		return l.certificate, nil
+	}
+
+	if modTime.Equal(l.modTime) {
+		return l.certificate, nil
+	}
+
+	// Keep serving the old certificate if the new one is broken or half written
+	if err := l.load(modTime); err != nil {
+		logrus.Errorf("failed to reload certificate: %s", err)
+
+		return l.certificate, nil
+	}
+
+	logrus.Infof("certificate %s is reloaded", l.certificatePath)
+
+	return l.certificate, nil
+}
+
+func (l *certificateLoader) load(modTime time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(l.certificatePath, l.privateKeyPath)
+	if err != nil {
+		return err
+	}
+
+	l.certificate = &certificate
+	l.modTime = modTime
+
+	return nil
+}
+
+// lastModified returns the latest modification time of the certificate and private key,
+// os.Stat follows the symbolic links used by /etc/letsencrypt/live
+func (l *certificateLoader) lastModified() (time.Time, error) {
+	var modTime time.Time
+
+	for _, path := range []string{l.certificatePath, l.privateKeyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	return modTime, nil
+}
+
+func newCertificateLoader(certificatePath, privateKeyPath string) (*certificateLoader, error) {
+	loader := certificateLoader{
+		certificatePath: certificatePath,
+		privateKeyPath:  privateKeyPath,
+		checkedAt:       time.Now(),
+	}
+
+	modTime, err := loader.lastModified()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loader.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return &loader, nil
+}
+
+// webSocketTLS lets browsers negotiate HTTP/1.1, they fail the handshake when ALPN only offers the ACME protocol
+func webSocketTLS(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil || len(tlsConfig.NextProtos) == 0 {
+		return tlsConfig
+	}
+
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{"http/1.1", acme.ALPNProto}
+
+	return tlsConfig
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func newTestCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pool.example"},
+		DNSNames:     []string{"pool.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake reports whether a client without certificate completes the handshake offering the protocols
+func handshake(t *testing.T, serverConfig *tls.Config, protos []string) error {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		_ = tls.Server(serverConn, serverConfig).Handshake()
+		_ = serverConn.Close()
+	}()
+
+	client := tls.Client(clientConn, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         protos,
+		// TLS 1.3 reports a refused client certificate only on the first read
+		MaxVersion: tls.VersionTLS12,
+	})
+
+	return client.Handshake()
+}
+
+func TestAllowChallenge(t *testing.T) {
+	certificate := newTestCertificate(t)
+
+	tlsConfig := tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   []string{acme.ALPNProto},
+		ClientCAs:    x509.NewCertPool(),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	allowChallenge(&tlsConfig)
+
+	if err := handshake(t, &tlsConfig, []string{acme.ALPNProto}); err != nil {
+		t.Errorf("challenge handshake failed: %s", err)
+	}
+
+	// Miners still need a certificate
+	if err := handshake(t, &tlsConfig, nil); err == nil {
+		t.Error("handshake without client certificate succeeded")
+	}
+}
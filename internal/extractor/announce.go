@@ -0,0 +1,81 @@
+package extractor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+	"github.com/tier2pool/tier2pool/internal/stratum"
+)
+
+// announce sends the fee message right after the origin pool answered the miner's first authorize,
+// miners that don't know client.show_message ignore it
+func (e *extractor) announce(ctx context.Context, header jsonrpc.Header) error {
+	if !e.option.Announce {
+		return nil
+	}
+
+	e.upstreamLock.Lock()
+	request := e.authorizeRequest
+	if request == nil || e.announced || string(header.ID) != string(request.ID) {
+		e.upstreamLock.Unlock()
+
+		return nil
+	}
+
+	e.announced = true
+	e.upstreamLock.Unlock()
+
+	params, err := json.Marshal(stratum.ClientShowMessageParams{e.feeMessage()})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(jsonrpc.Notification{
+		JSONRPC: request.JSONRPC,
+		Method:  stratum.MethodClientShowMessage,
+		Params:  params,
+		Extra: map[string]json.RawMessage{
+			"id": json.RawMessage("null"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.localPeer.send(ctx, data)
+}
+
+// feeMessage describes the fee of the session, like
+// fee 2.00% of shares: 1.00% to inject (0x0000 on eu1.ethermine.org:5555), 1.00% to develop (...)
+func (e *extractor) feeMessage() string {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	total := 0.0
+	destinations := make([]string, 0, len(e.fees))
+
+	for _, up := range e.fees {
+		weight := e.weights[up.Route]
+		if weight <= 0 {
+			continue
+		}
+
+		total += weight
+
+		pool := up.Pool
+		if i := strings.Index(pool, "://"); i >= 0 {
+			pool = pool[i+3:]
+		}
+
+		destinations = append(destinations, fmt.Sprintf("%.2f%% to %s (%s on %s)", weight*100, up.Route, up.Wallet, pool))
+	}
+
+	if total == 0 {
+		return "no fee is taken from your shares"
+	}
+
+	return fmt.Sprintf("fee %.2f%% of shares: %s", total*100, strings.Join(destinations, ", "))
+}
@@ -1,28 +1,32 @@
 package extractor
 
 import (
-	"bufio"
 	"context"
-	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
-	"math/big"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
 	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+	"github.com/tier2pool/tier2pool/internal/ledger"
+	"github.com/tier2pool/tier2pool/internal/metrics"
+	"github.com/tier2pool/tier2pool/internal/router"
+	"github.com/tier2pool/tier2pool/internal/rule"
+	"github.com/tier2pool/tier2pool/internal/store"
 	"github.com/tier2pool/tier2pool/internal/stratum"
 	"github.com/tier2pool/tier2pool/internal/token"
 	"golang.org/x/sync/errgroup"
 )
 
 const (
+	LogMinerOutbound = "-> Miner"
+
 	LogOriginInbound  = "<- Origin"
 	LogOriginOutbound = "-> Origin"
 
@@ -32,24 +36,68 @@ const (
 	LogDevelopInbound  = "<- Develop"
 	LogDevelopOutbound = "-> Develop"
 
-	JobInject  = "inject"
-	JobDevelop = "develop"
+	JobOrigin  = router.RouteOrigin
+	JobInject  = router.RouteInject
+	JobDevelop = router.RouteDevelop
+
+	DefaultTimeout     = time.Second * 3
+	DefaultIdleTimeout = time.Minute * 5
 
-	Thread     = 3             // Correction probability
-	WeightUnit = 1000 * Thread // 1‰
+	// Shares of a fee job are routed to its pool for so long after it was sent
+	jobTTL = time.Minute
 )
 
 var (
-	ErrDataIsTooLong = errors.New("data is too long")
+	ErrWorkerNotAllowed = errors.New("worker is not allowed")
 )
 
 type Option struct {
-	Token   string
-	Pool    string
-	Wallet  string
-	Weight  float64
-	Rename  string
+	Token string
+
+	// Injects are the pools the fee is shared between by their weights
+	Injects []Destination
+
+	// Develop is the sponsor's destination, see DefaultDevelop, nil means no develop fee
+	Develop *Destination
+
+	// Timeout bounds every write in seconds, zero means DefaultTimeout
 	Timeout int
+
+	// IdleTimeout closes a connection that has been silent for so many seconds, zero means DefaultIdleTimeout
+	IdleTimeout int
+
+	// Router decides which pool's jobs the miner works on, see router.New
+	Router string
+
+	// RouterPeriod is the cycle of the timeslice router in seconds
+	RouterPeriod int
+
+	// MaxLineLength limits the size of a message, zero means jsonrpc.DefaultMaxLineLength
+	MaxLineLength int
+
+	// Dialer resolves the TLS settings of each pool, nil means the system defaults
+	Dialer *jsonrpc.Dialer
+
+	// Observer receives the time the origin pool took to answer each share, it can be nil
+	Observer ShareObserver
+
+	// Prefixes restricts the wallets or workers a miner can authorize, empty means no restriction
+	Prefixes []string
+
+	// KeyPrefix is put in front of the job store keys, empty means store.DefaultPrefix
+	KeyPrefix string
+
+	// Ledger records every share, it can be nil
+	Ledger *ledger.Ledger
+
+	// Rules decide the inject weight of a miner when it authorizes, nil means the weights of Injects for everyone
+	Rules *rule.Set
+
+	// Listener is the address the miner connected to, rules can match it
+	Listener string
+
+	// Announce tells the miner its fee ratio and destinations with a client.show_message once it's authorized
+	Announce bool
 }
 
 // Gentlemen's agreement
@@ -63,9 +111,49 @@ var (
 	defaultDevelopPoolETC = "tls://asia1-etc.ethermine.org:5555"
 	defaultDevelopPoolXMR = "tcp://sg.minexmr.com:4444"
 
-	defaultDevelopWeight = 0.01 // 1%
+	defaultDevelopWorker = "sponsors"
+
+	DefaultDevelopWeight = 0.01 // 1%
 )
 
+// DefaultDevelop returns the sponsor destination of the token, it fails for a token the sponsor doesn't mine
+func DefaultDevelop(tokenName string) (Destination, error) {
+	develop := Destination{
+		Route:  JobDevelop,
+		Worker: defaultDevelopWorker,
+		Weight: DefaultDevelopWeight,
+	}
+
+	// TODO Replace it with a hash table
+	switch tokenName {
+	case token.ETH:
+		develop.Pool, develop.Wallet = defaultDevelopPoolETH, defaultDevelopWalletEthereum
+	case token.ETC:
+		develop.Pool, develop.Wallet = defaultDevelopPoolETC, defaultDevelopWalletEthereum
+	case token.XMR:
+		develop.Pool, develop.Wallet = defaultDevelopPoolXMR, defaultDevelopWalletMonero
+	default:
+		return develop, fmt.Errorf("%s token isn't supported by the develop fee", tokenName)
+	}
+
+	return develop, nil
+}
+
+// All sessions are accounted together as well, see Report
+var globalAccount = router.NewAccount()
+
+// Report returns the target and achieved fee ratio of all sessions since the start
+func Report() []router.Report {
+	return globalAccount.Report()
+}
+
+type ShareObserver interface {
+	ObserveShare(url string, latency time.Duration)
+}
+
+// Shares the pool never answered are dropped once there are too many of them
+const maxPendingShares = 1024
+
 type Extractor interface {
 	Inject() error
 	Close()
@@ -73,25 +161,93 @@ type Extractor interface {
 
 var _ Extractor = &extractor{}
 
+// extractor runs a session as a pipeline, every connection has one reader and one writer goroutine,
+// readers route messages into the outboxes of the other connections
 type extractor struct {
 	option        Option
-	localConn     jsonrpc.Conn
-	remoteConn    jsonrpc.Conn
-	injectConn    jsonrpc.Conn
-	developConn   jsonrpc.Conn
-	injectWeight  int64
-	developWeight int64
-	redisClient   *redis.Client
-	locker        sync.Mutex
+	localPeer     *peer
+	remotePeer    *peer
+	router        router.Router
+	weights       map[string]float64
+	account       *router.Account
+	jobStore      store.JobStore
+	namespace     store.Namespace
+	remoteURL     string
+	pendingShares map[string]time.Time
+	pendingLocker sync.Mutex
+
+	// active is the route the miner works for, the switch lock serializes every job message to the miner
+	active      string
+	states      map[string]*routeState
+	subscribeID string
+	switchLock  sync.Mutex
+
+	// storeFailedAt is the last time the job store failed, fee jobs are paused for a while after it
+	storeFailedAt time.Time
+
+	// Fee upstreams are opened in the session's group once they're needed,
+	// they log in with the subscribe and authorize of the miner
+	fees             []*upstream
+	group            *errgroup.Group
+	subscribeData    []byte
+	authorizeRequest *jsonrpc.Request
+	announced        bool
+	upstreamLock     sync.Mutex
+
+	// loggedIn is set once a login passed the prefixes, only the inbound reader uses it
+	loggedIn bool
+
+	// Shares waiting for the answer of their pool before they're recorded to the ledger
+	wallet         string
+	worker         string
+	pendingEntries map[string][]ledger.Share
+	pendingCount   int
+	ledgerLock     sync.Mutex
 }
 
 func (e *extractor) Inject() error {
 	defer e.Close()
+	defer e.report()
+	defer e.flush()
+
+	eg, ctx := errgroup.WithContext(context.Background())
+
+	e.upstreamLock.Lock()
+	e.group = eg
+	e.upstreamLock.Unlock()
+
+	// Closing the connections is the only way to interrupt blocked reads, fee upstreams close themselves
+	eg.Go(func() error {
+		<-ctx.Done()
+
+		e.Close()
+
+		return nil
+	})
+
+	for _, p := range []*peer{e.localPeer, e.remotePeer} {
+		p := p
+
+		eg.Go(func() error {
+			return p.write(ctx)
+		})
+	}
 
-	eg := errgroup.Group{}
+	eg.Go(func() error {
+		return e.localPeer.read(e.option.MaxLineLength, func(data []byte) error {
+			return e.handleInbound(ctx, data)
+		})
+	})
 
-	eg.Go(e.handleInbound)
-	eg.Go(e.handleOutbound)
+	eg.Go(func() error {
+		return e.remotePeer.read(e.option.MaxLineLength, func(data []byte) error {
+			return e.handleOutboundOrigin(ctx, data)
+		})
+	})
+
+	eg.Go(func() error {
+		return e.schedule(ctx)
+	})
 
 	if err := eg.Wait(); err != nil {
 		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
@@ -105,474 +261,539 @@ func (e *extractor) Inject() error {
 }
 
 func (e *extractor) Close() {
-	_ = e.localConn.Close()
-	_ = e.remoteConn.Close()
-	_ = e.developConn.Close()
+	for _, p := range e.peers() {
+		_ = p.conn.Close()
+	}
+}
+
+func (e *extractor) peers() []*peer {
+	peers := []*peer{e.localPeer, e.remotePeer}
 
-	if e.injectConn != nil {
-		_ = e.injectConn.Close()
+	e.upstreamLock.Lock()
+	defer e.upstreamLock.Unlock()
+
+	for _, up := range e.fees {
+		if up.peer != nil {
+			peers = append(peers, up.peer)
+		}
 	}
+
+	return peers
 }
 
-func (e *extractor) handleInbound() error {
-	defer func() {
-		_ = e.localConn.Close()
-	}()
+func (e *extractor) handleInbound(ctx context.Context, data []byte) error {
+	header, err := jsonrpc.ParseHeader(data)
+	if err != nil {
+		return err
+	}
+
+	if err := e.checkLogin(header.Method, data); err != nil {
+		return err
+	}
 
-	reader := bufio.NewReader(e.localConn)
+	switch header.Method {
+	case stratum.MethodNiceHashSubscribe:
+		e.switchLock.Lock()
+		e.subscribeID = string(header.ID)
+		e.switchLock.Unlock()
 
-	for {
-		_ = e.developConn.SetReadDeadlineBySecond(e.option.Timeout)
+		// Fee upstreams opened later are subscribed the same way
+		e.upstreamLock.Lock()
+		e.subscribeData = clone(data)
+		e.upstreamLock.Unlock()
 
-		data, isPrefix, err := reader.ReadLine()
-		if err != nil && len(data) == 0 {
+		if err := e.remotePeer.send(ctx, data); err != nil {
+			return err
+		}
+	case stratum.MethodNiceHashAuthorize:
+		request := jsonrpc.Request{}
+		if err := json.Unmarshal(data, &request); err != nil {
 			return err
 		}
 
-		if isPrefix {
-			return ErrDataIsTooLong
+		wallet, worker := e.observeLogin(request)
+
+		e.upstreamLock.Lock()
+		if e.authorizeRequest == nil {
+			e.authorizeRequest = &request
 		}
+		e.upstreamLock.Unlock()
 
-		request := jsonrpc.Request{}
+		// The fee is decided before the pool can answer, so the announcement tells the right one
+		if err := e.applyRule(wallet, worker); err != nil {
+			return err
+		}
 
-		if err = json.Unmarshal(data, &request); err != nil {
+		if err := e.remotePeer.send(ctx, data); err != nil {
 			return err
 		}
 
-		switch request.Method {
-		case stratum.MethodNiceHashSubscribe:
-			if _, err = e.remoteConn.Write(data); err != nil {
-				return err
+		// Only the destinations the miner's rule gives work to are connected, a scheduling router opens each of them
+		// ahead of its window and closes it when idle. The other routers mix fee jobs in at any moment, so these
+		// upstreams stay open for the whole session and laziness only spares the ones of zero weight
+		if !e.scheduled() {
+			for _, up := range e.fees {
+				if e.weight(up.Route) > 0 {
+					e.open(ctx, up)
+				}
 			}
+		}
+	case stratum.MethodNiceHashSubmit:
+		request := jsonrpc.Request{}
+		if err := json.Unmarshal(data, &request); err != nil {
+			return err
+		}
 
-			logrus.Debug(LogOriginOutbound, string(data))
+		params := stratum.NiceHashSubmitParams{}
+		if err := json.Unmarshal(request.Params, &params); err != nil {
+			return err
+		}
 
-			if e.injectConn != nil {
-				if _, err = e.injectConn.Write(data); err != nil {
-					return err
-				}
-			}
+		if params == nil || len(params) < 2 {
+			return errors.New("invalid parameter")
+		}
 
-			logrus.Debug(LogInjectOutbound, string(data))
+		if err := e.handleSubmit(ctx, params[1], request, data); err != nil {
+			return err
+		}
+	default:
+		if err := e.remotePeer.send(ctx, data); err != nil {
+			return err
+		}
+	}
 
-			if _, err = e.developConn.Write(data); err != nil {
-				return err
-			}
+	return nil
+}
 
-			logrus.Debug(LogDevelopOutbound, string(data))
-		case stratum.MethodNiceHashAuthorize:
-			request := jsonrpc.Request{}
-			if err := json.Unmarshal(data, &request); err != nil {
-				return err
-			}
+func (e *extractor) handleOutboundOrigin(ctx context.Context, data []byte) error {
+	logrus.Debug(LogOriginInbound, string(data))
 
-			if _, err := e.remoteConn.Write(data); err != nil {
-				return err
-			}
+	header, err := jsonrpc.ParseHeader(data)
+	if err != nil {
+		return err
+	}
 
-			logrus.Debug(LogOriginOutbound, string(data))
+	switch header.Method {
+	case stratum.MethodNiceHashNotify, stratum.MethodNiceHashSetDifficulty, stratum.MethodNiceHashSetExtranonce:
+		return e.forward(ctx, JobOrigin, header.Method, data)
+	case "":
+		e.observeSubscribe(JobOrigin, header, data)
+		e.observeShare(header)
+		e.observeResult(JobOrigin, header, data)
 
-			if e.injectConn != nil {
-				injectParams, err := json.Marshal(stratum.NiceHashSubmitParams{
-					fmt.Sprintf("%s.%s", e.option.Wallet, e.option.Rename),
-					"x",
-				})
-				if err != nil {
-					return err
-				}
+		if err := e.localPeer.send(ctx, data); err != nil {
+			return err
+		}
 
-				request.Params = injectParams
+		return e.announce(ctx, header)
+	}
 
-				injectData, err := json.Marshal(request)
-				if err != nil {
-					return err
-				}
+	return e.localPeer.send(ctx, data)
+}
 
-				if _, err := e.injectConn.Write(injectData); err != nil {
-					return err
-				}
+// handleNotify forwards the jobs of a fee pool to the miner, other messages stay between the tunnel and the pool
+func (e *extractor) handleNotify(ctx context.Context, data []byte, job string) error {
+	logrus.Debug(logPrefix("<-", job), string(data))
 
-				logrus.Debug(LogInjectOutbound, string(injectData))
-			}
+	header, err := jsonrpc.ParseHeader(data)
+	if err != nil {
+		return err
+	}
 
-			var developWallet string
+	switch header.Method {
+	case stratum.MethodNiceHashNotify, stratum.MethodNiceHashSetDifficulty, stratum.MethodNiceHashSetExtranonce:
+		return e.forward(ctx, job, header.Method, data)
+	case "":
+		e.observeSubscribe(job, header, data)
+		e.observeHandshake(job, header, data)
+		e.observeResult(job, header, data)
+	}
 
-			switch e.option.Token {
-			case token.ETH, token.ETC:
-				developWallet = defaultDevelopWalletEthereum
-			case token.XMR:
-				developWallet = defaultDevelopWalletMonero
-			default:
-				// TODO Support more tokens
-				developWallet = e.option.Wallet
-			}
+	return nil
+}
 
-			developParams, err := json.Marshal(stratum.NiceHashSubmitParams{
-				fmt.Sprintf("%s.%s", developWallet, "sponsors"),
-				"x",
-			})
-			if err != nil {
-				return err
-			}
+// lookupJob returns the route of the job, empty while the store is degraded
+func (e *extractor) lookupJob(ctx context.Context, keys []string) (string, error) {
+	if e.degraded() {
+		return "", nil
+	}
 
-			request.Params = developParams
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
 
-			developData, err := json.Marshal(request)
-			if err != nil {
-				return err
-			}
+	return e.jobStore.Get(ctx, keys...)
+}
 
-			if _, err := e.developConn.Write(developData); err != nil {
-				return err
-			}
+func (e *extractor) handleSubmit(ctx context.Context, id string, request jsonrpc.Request, data []byte) error {
+	keys := make([]string, 0, len(e.fees))
+	for _, up := range e.fees {
+		keys = append(keys, e.namespace.Key(up.Route, id))
+	}
 
-			logrus.Debug(LogDevelopOutbound, string(developData))
-		case stratum.MethodNiceHashSubmit:
-			params := stratum.NiceHashSubmitParams{}
-			if err := json.Unmarshal(request.Params, &params); err != nil {
-				return err
-			}
+	// Jobs that can't be looked up are submitted to the origin pool, while the store is failing it isn't even asked
+	job, err := e.lookupJob(ctx, keys)
+	if err != nil {
+		e.storeFailed(fmt.Errorf("failed to look up job %s: %w", id, err))
+	}
 
-			if params == nil || len(params) < 2 {
-				return errors.New("invalid parameter")
-			}
+	var p *peer
+	if job != "" {
+		p = e.feePeer(job)
+	}
 
-			if err := e.handleSubmit(params[1], data); err != nil {
-				return err
-			}
-		default:
-			if _, err := e.remoteConn.Write(data); err != nil {
-				return err
-			}
+	// Jobs of no fee pool belong to the origin pool
+	if p == nil {
+		e.submitted(JobOrigin)
+		e.recordSubmit(JobOrigin, id, request)
 
-			logrus.Debug(LogOriginOutbound, string(data))
-		}
+		e.trackShare(data)
+
+		return e.remotePeer.send(ctx, data)
 	}
-}
 
-func (e *extractor) handleOutbound() error {
-	eg := errgroup.Group{}
+	e.submitted(job)
+	e.recordSubmit(job, id, request)
+
+	if request.Worker != "" {
+		request.Worker = e.fee(job).Worker
+	}
+
+	submitData, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
 
-	eg.Go(e.handleOutboundOrigin)
-	eg.Go(e.handleOutboundDevelop)
+	if err := p.send(ctx, submitData); err != nil {
+		return err
+	}
 
-	if e.injectConn != nil {
-		eg.Go(e.handleOutboundInject)
+	result, err := json.Marshal(true)
+	if err != nil {
+		return err
 	}
 
-	return eg.Wait()
+	responseData, err := json.Marshal(jsonrpc.Response{
+		JSONRPC: request.JSONRPC,
+		ID:      request.ID,
+		Result:  result,
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.localPeer.send(ctx, responseData)
 }
 
-func (e *extractor) handleOutboundOrigin() error {
-	defer func() {
-		_ = e.remoteConn.Close()
-	}()
+// submitted accounts the difficulty of a share to its route
+func (e *extractor) submitted(route string) {
+	difficulty := e.difficulty(route)
 
-	reader := bufio.NewReader(e.remoteConn)
+	e.switchLock.Lock()
+	shareRouter, weights := e.router, e.weights
+	e.switchLock.Unlock()
 
-	for {
-		if err := e.developConn.SetReadDeadlineBySecond(e.option.Timeout); err != nil {
-			return err
-		}
+	if shareRouter != nil {
+		shareRouter.Submitted(route, difficulty)
+	}
 
-		data, isPrefix, err := reader.ReadLine()
-		if err != nil && len(data) == 0 {
-			return err
-		}
+	e.account.Add(route, difficulty, weights)
+	globalAccount.Add(route, difficulty, weights)
 
-		if isPrefix {
-			return ErrDataIsTooLong
-		}
+	metrics.RouteDifficulty.WithLabelValues(route).Add(math.Max(difficulty, 1))
+}
 
-		if err := e.inject(data); err != nil {
-			return err
-		}
+// flush records the shares the pools never answered
+func (e *extractor) flush() {
+	e.ledgerLock.Lock()
+	defer e.ledgerLock.Unlock()
+
+	e.flushEntries()
+}
+
+// report logs how close the session came to the configured fee
+func (e *extractor) report() {
+	if e.account.Total() == 0 {
+		return
+	}
 
-		logrus.Debug(LogOriginInbound, string(data))
+	for _, report := range e.account.Report() {
+		logrus.Infof(
+			"%s worked %.2f%% for %s, target %.2f%%",
+			e.localPeer.conn.RemoteAddr(), report.Achieved*100, report.Route, report.Target*100,
+		)
 	}
 }
 
-func (e *extractor) handleOutboundInject() error {
-	defer func() {
-		if e.injectConn != nil {
-			_ = e.injectConn.Close()
-		}
-	}()
+// trackShare remembers when a share was forwarded to the origin pool
+func (e *extractor) trackShare(data []byte) {
+	if e.option.Observer == nil {
+		return
+	}
 
-	reader := bufio.NewReader(e.injectConn)
+	header, err := jsonrpc.ParseHeader(data)
+	if err != nil || header.ID.IsNull() {
+		return
+	}
 
-	for {
-		if err := e.injectConn.SetReadDeadlineBySecond(e.option.Timeout); err != nil {
-			return err
-		}
+	e.pendingLocker.Lock()
+	defer e.pendingLocker.Unlock()
 
-		data, isPrefix, err := reader.ReadLine()
-		if err != nil && len(data) == 0 {
-			return err
-		}
+	if len(e.pendingShares) >= maxPendingShares {
+		e.pendingShares = make(map[string]time.Time)
+	}
 
-		if isPrefix {
-			return ErrDataIsTooLong
-		}
+	e.pendingShares[string(header.ID)] = time.Now()
+}
 
-		request := jsonrpc.Request{}
-		if err := json.Unmarshal(data, &request); err != nil {
-			return err
-		}
+// observeShare reports the latency if the response answers a tracked share
+func (e *extractor) observeShare(header jsonrpc.Header) {
+	if e.option.Observer == nil {
+		return
+	}
 
-		switch request.Method {
-		case stratum.MethodNiceHashNotify:
-			n, err := rand.Int(rand.Reader, big.NewInt(WeightUnit))
-			if err != nil {
-				return err
-			}
+	e.pendingLocker.Lock()
+	defer e.pendingLocker.Unlock()
 
-			if n.Int64() < e.injectWeight {
-				params := stratum.NiceHashNotifyParams{}
-				if err := json.Unmarshal(request.Params, &params); err != nil {
-					return err
-				}
+	if len(e.pendingShares) == 0 {
+		return
+	}
 
-				id, ok := params[0].(string)
-				if !ok {
-					return errors.New("id format not support")
-				}
+	if forwardedAt, ok := e.pendingShares[string(header.ID)]; ok {
+		delete(e.pendingShares, string(header.ID))
 
-				if err := e.redisClient.Set(context.Background(), id, JobInject, time.Minute).Err(); err != nil {
-					return err
-				}
+		e.option.Observer.ObserveShare(e.remoteURL, time.Since(forwardedAt))
+	}
+}
 
-				if err := e.inject(data); err != nil {
-					return err
-				}
+// checkLogin enforces the prefixes of the miner's certificate on every login method,
+// other methods than subscribing are refused until the miner logged in
+func (e *extractor) checkLogin(method string, data []byte) error {
+	if len(e.option.Prefixes) == 0 {
+		return nil
+	}
 
-				logrus.Debug(LogInjectInbound, string(data))
-			}
-		default:
-			logrus.Debug(LogInjectInbound, string(data))
+	switch method {
+	case stratum.MethodNiceHashSubscribe, stratum.MethodNiceHashExtranonceSubscribe:
+		return nil
+	case stratum.MethodNiceHashAuthorize, stratum.MethodOpenPoolSubmitLogin, stratum.MethodMoneroLogin:
+	default:
+		if !e.loggedIn {
+			return fmt.Errorf("%w: %s before login", ErrWorkerNotAllowed, method)
 		}
+
+		return nil
 	}
-}
 
-func (e *extractor) handleOutboundDevelop() error {
-	defer func() {
-		_ = e.developConn.Close()
-	}()
+	request := jsonrpc.Request{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return err
+	}
 
-	reader := bufio.NewReader(e.developConn)
+	login, err := parseLogin(method, request.Params)
+	if err != nil {
+		return err
+	}
 
-	for {
-		if err := e.developConn.SetReadDeadlineBySecond(e.option.Timeout); err != nil {
-			return err
-		}
+	for _, prefix := range e.option.Prefixes {
+		if matchPrefix(login, prefix) {
+			e.loggedIn = true
 
-		data, isPrefix, err := reader.ReadLine()
-		if err != nil && len(data) == 0 {
-			return err
+			return nil
 		}
+	}
 
-		if isPrefix {
-			return ErrDataIsTooLong
-		}
+	return fmt.Errorf("%w: %s", ErrWorkerNotAllowed, login)
+}
 
-		request := jsonrpc.Request{}
-		if err := json.Unmarshal(data, &request); err != nil {
-			return err
+// parseLogin returns the wallet and worker a login method logs in as
+func parseLogin(method string, rawParams json.RawMessage) (string, error) {
+	if method == stratum.MethodMoneroLogin {
+		params := stratum.MoneroLoginParams{}
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return "", err
 		}
 
-		switch request.Method {
-		case stratum.MethodNiceHashNotify:
-			n, err := rand.Int(rand.Reader, big.NewInt(WeightUnit))
-			if err != nil {
-				return err
-			}
+		return params.Login, nil
+	}
 
-			if n.Int64() < e.developWeight {
-				params := stratum.NiceHashNotifyParams{}
-				if err := json.Unmarshal(request.Params, &params); err != nil {
-					return err
-				}
+	// mining.authorize and eth_submitLogin both start with the wallet and worker
+	params := stratum.NiceHashAuthorizeParams{}
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		return "", err
+	}
 
-				if params == nil || len(params) == 0 {
-					return errors.New("invalid parameter")
-				}
+	if len(params) == 0 {
+		return "", errors.New("invalid parameter")
+	}
 
-				id, ok := params[0].(string)
-				if !ok {
-					return errors.New("id format not support")
-				}
+	return params[0], nil
+}
 
-				if err := e.redisClient.Set(context.Background(), id, JobDevelop, time.Minute).Err(); err != nil {
-					return err
-				}
+// Separators between a wallet and its worker, or a worker and the rest of its name
+const loginSeparators = "./+"
 
-				if err := e.inject(data); err != nil {
-					return err
-				}
+// matchPrefix reports whether the login is the prefix or starts with it at a name boundary,
+// so that the prefix rig01 doesn't allow rig010
+func matchPrefix(login, prefix string) bool {
+	if !strings.HasPrefix(login, prefix) {
+		return false
+	}
 
-				logrus.Debug(LogDevelopInbound, string(data))
-			}
-		default:
-			logrus.Debug(LogDevelopInbound, string(data))
-		}
+	if len(login) == len(prefix) || prefix == "" || strings.ContainsRune(loginSeparators, rune(prefix[len(prefix)-1])) {
+		return true
 	}
-}
 
-func (e *extractor) handleSubmit(id string, data []byte) error {
-	// Query value form Redis
-	switch e.redisClient.Get(context.Background(), id).Val() {
-	case JobInject:
-		request := jsonrpc.Request{}
-		if err := json.Unmarshal(data, &request); err != nil {
-			return err
-		}
+	return strings.ContainsRune(loginSeparators, rune(login[len(prefix)]))
+}
 
-		if request.Worker != "" {
-			request.Worker = e.option.Rename
-		}
+// authorizeAs rewrites the authorize request to log in with another wallet and worker
+func authorizeAs(request jsonrpc.Request, wallet, worker string) ([]byte, error) {
+	params, err := json.Marshal(stratum.NiceHashAuthorizeParams{
+		fmt.Sprintf("%s.%s", wallet, worker),
+		"x",
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		injectData, err := json.Marshal(request)
-		if err != nil {
-			return err
-		}
+	request.Params = params
 
-		if _, err := e.injectConn.Write(injectData); err != nil {
-			return err
-		}
+	return json.Marshal(request)
+}
 
-		logrus.Debug(LogInjectOutbound, string(injectData))
+// scheduled reports whether the router gives the miner to one route at a time
+func (e *extractor) scheduled() bool {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
 
-		result, err := json.Marshal(true)
-		if err != nil {
-			return err
-		}
+	_, ok := e.router.(router.Scheduler)
 
-		response := jsonrpc.Request{
-			ID:     request.ID,
-			Result: result,
-		}
+	return ok
+}
 
-		responseData, err := json.Marshal(response)
-		if err != nil {
-			return err
-		}
+// weight returns the weight of the route for the session, zero before the miner authorized
+func (e *extractor) weight(route string) float64 {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
 
-		if err := e.inject(responseData); err != nil {
-			return err
-		}
-	case JobDevelop:
-		request := jsonrpc.Request{}
-		if err := json.Unmarshal(data, &request); err != nil {
-			return err
-		}
+	return e.weights[route]
+}
 
-		if request.Worker != "" {
-			request.Worker = "sponsors"
-		}
+// applyRule routes the session by the fee rule the miner matches, the first authorize decides it
+func (e *extractor) applyRule(wallet, worker string) error {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
 
-		developData, err := json.Marshal(request)
-		if err != nil {
-			return err
-		}
+	if e.router != nil {
+		return nil
+	}
 
-		if _, err := e.developConn.Write(developData); err != nil {
-			return err
+	total, develop := 0.0, 0.0
+	for _, up := range e.fees {
+		if up.Route == JobDevelop {
+			develop = up.Weight
+		} else {
+			total += up.Weight
 		}
+	}
 
-		logrus.Debug(LogDevelopOutbound, string(developData))
+	fee := rule.Rule{
+		Name:   rule.NameDefault,
+		Weight: math.Min(total+develop, 1),
+	}
 
-		result, err := json.Marshal(true)
-		if err != nil {
-			return err
-		}
+	if e.option.Rules != nil {
+		fee = e.option.Rules.Match(rule.Miner{
+			Wallet:   wallet,
+			Worker:   worker,
+			Address:  e.localPeer.conn.RemoteAddr(),
+			Listener: e.option.Listener,
+		})
+	}
 
-		response := jsonrpc.Request{
-			ID:     request.ID,
-			Result: result,
-		}
+	// The rule sets the total fee, the develop fee is taken first, up to the total, so a rule of weight 0 takes nothing,
+	// and the inject destinations share the rest by their weights
+	develop = math.Min(develop, fee.Weight)
+	inject := fee.Weight - develop
 
-		responseData, err := json.Marshal(response)
-		if err != nil {
-			return err
-		}
+	weights := make(map[string]float64, len(e.fees))
 
-		if err := e.inject(responseData); err != nil {
-			return err
-		}
-	default:
-		if _, err := e.remoteConn.Write(data); err != nil {
-			return err
+	for _, up := range e.fees {
+		switch {
+		case up.Route == JobDevelop:
+			weights[up.Route] = develop
+		case total > 0:
+			weights[up.Route] = inject * up.Weight / total
 		}
-
-		logrus.Debug(LogOriginOutbound, string(data))
 	}
 
-	return nil
-}
-
-func (e *extractor) inject(data []byte) error {
-	e.locker.Lock()
-
-	if _, err := e.localConn.Write(data); err != nil {
+	shareRouter, err := router.New(router.Option{
+		Name:    e.option.Router,
+		Weights: weights,
+		Period:  time.Second * time.Duration(e.option.RouterPeriod),
+	})
+	if err != nil {
 		return err
 	}
 
-	logrus.Debug(LogOriginInbound, string(data))
+	e.router, e.weights = shareRouter, weights
 
-	e.locker.Unlock()
+	logrus.Infof("%s matched fee rule %s of %.2f%%", e.localPeer.conn.RemoteAddr(), fee.Name, fee.Weight*100)
 
 	return nil
 }
 
-func New(redisClient *redis.Client, localConn net.Conn, remoteRawURL string, option Option) (Extractor, error) {
+func New(jobStore store.JobStore, localConn net.Conn, remoteRawURL string, option Option) (Extractor, error) {
 	// By default, will mine Ethereum
 	if option.Token == "" {
 		option.Token = token.ETH
 	}
 
-	remoteConn, err := jsonrpc.Dial(remoteRawURL)
+	session, err := store.NewSession()
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO Replace it with a hash table
-	var developConn jsonrpc.Conn
-	switch option.Token {
-	case token.ETH:
-		developConn, err = jsonrpc.Dial(defaultDevelopPoolETH)
-	case token.ETC:
-		developConn, err = jsonrpc.Dial(defaultDevelopPoolETC)
-	case token.XMR:
-		developConn, err = jsonrpc.Dial(defaultDevelopPoolXMR)
-	default:
-		return nil, fmt.Errorf("%s token isn't supported", option.Token)
-	}
-
+	remoteConn, err := option.Dialer.Dial(remoteRawURL)
 	if err != nil {
-		_ = remoteConn.Close()
+		return nil, err
 	}
 
-	var injectConn jsonrpc.Conn
+	fees := make([]*upstream, 0, len(option.Injects)+1)
+
+	for _, destination := range option.Injects {
+		fees = append(fees, &upstream{
+			Destination: destination,
+		})
+	}
 
-	if option.Pool != "" {
-		injectConn, err = jsonrpc.Dial(option.Pool)
-		if err != nil {
-			_ = remoteConn.Close()
-			_ = developConn.Close()
+	// The develop pool is only connected when its turn comes like the others,
+	// if it can't be reached the miner keeps working for the origin pool
+	if option.Develop != nil && option.Develop.Weight > 0 {
+		develop := *option.Develop
+		develop.Route = JobDevelop
 
-			return nil, err
-		}
+		fees = append(fees, &upstream{
+			Destination: develop,
+		})
 	}
 
 	return &extractor{
-		localConn:   jsonrpc.New(localConn),
-		remoteConn:  remoteConn,
-		injectConn:  injectConn,
-		developConn: developConn,
-		// n < min(injectWeight, MaxWeight - developWeight)
-		injectWeight:  int64(math.Min(float64(WeightUnit)*option.Weight, float64(WeightUnit-int64(float64(WeightUnit)*defaultDevelopWeight)))),
-		developWeight: int64(float64(WeightUnit) * defaultDevelopWeight),
-		redisClient:   redisClient,
-		option:        option,
+		localPeer:      newPeer(jsonrpc.New(localConn), LogMinerOutbound, option),
+		remotePeer:     newPeer(remoteConn, LogOriginOutbound, option),
+		remoteURL:      remoteRawURL,
+		pendingShares:  make(map[string]time.Time),
+		fees:           fees,
+		account:        router.NewAccount(),
+		active:         JobOrigin,
+		states:         make(map[string]*routeState),
+		pendingEntries: make(map[string][]ledger.Share),
+		jobStore:       jobStore,
+		namespace: store.Namespace{
+			Prefix:  option.KeyPrefix,
+			Token:   option.Token,
+			Session: session,
+		},
+		option: option,
 	}, nil
 }
@@ -0,0 +1,614 @@
+package extractor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+	"github.com/tier2pool/tier2pool/internal/rule"
+	"github.com/tier2pool/tier2pool/internal/store"
+	"github.com/tier2pool/tier2pool/internal/stratum"
+)
+
+const testTimeout = time.Second * 5
+
+// fakePool answers the subscribe and authorize of every connection and records the requests it receives,
+// jobs are only notified when the test asks for them
+type fakePool struct {
+	t        *testing.T
+	name     string
+	url      string
+	requests chan jsonrpc.Request
+	conns    chan net.Conn
+	closed   chan struct{}
+	locker   sync.Mutex
+	conn     net.Conn
+}
+
+func newFakePool(t *testing.T, name string) *fakePool {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := fakePool{
+		t:        t,
+		name:     name,
+		url:      "tcp://" + listener.Addr().String(),
+		requests: make(chan jsonrpc.Request, 64),
+		conns:    make(chan net.Conn, 8),
+		closed:   make(chan struct{}, 8),
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			pool.locker.Lock()
+			pool.conn = conn
+			pool.locker.Unlock()
+
+			pool.conns <- conn
+
+			go pool.serve(conn)
+		}
+	}()
+
+	return &pool
+}
+
+func (p *fakePool) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+
+		p.closed <- struct{}{}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+
+	for scanner.Scan() {
+		request := jsonrpc.Request{}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			p.t.Errorf("%s received %s: %s", p.name, scanner.Bytes(), err)
+
+			return
+		}
+
+		p.requests <- request
+
+		var response string
+
+		switch request.Method {
+		case stratum.MethodNiceHashSubscribe:
+			response = fmt.Sprintf(`{"id":%s,"result":[["mining.notify","%s","EthereumStratum/1.0.0"],"%s"],"error":null}`,
+				request.ID, p.name, p.name[:2])
+		default:
+			response = fmt.Sprintf(`{"id":%s,"result":true,"error":null}`, request.ID)
+		}
+
+		p.write(response)
+	}
+}
+
+func (p *fakePool) write(line string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if p.conn != nil {
+		_, _ = p.conn.Write([]byte(line + "\n"))
+	}
+}
+
+// notify sends a job of the pool to its latest connection
+func (p *fakePool) notify(job string) {
+	p.write(fmt.Sprintf(`{"id":null,"method":"mining.set_difficulty","params":[%d]}`, len(p.name)))
+	p.write(fmt.Sprintf(`{"id":null,"method":"mining.notify","params":["%s","seed","header",false]}`, job))
+}
+
+// expect waits for a request of the method and returns it
+func (p *fakePool) expect(method string) jsonrpc.Request {
+	p.t.Helper()
+
+	timeout := time.After(testTimeout)
+
+	for {
+		select {
+		case request := <-p.requests:
+			if request.Method == method {
+				return request
+			}
+		case <-timeout:
+			p.t.Fatalf("%s didn't receive %s", p.name, method)
+		}
+	}
+}
+
+// expectNone fails when a request of the method arrives in a while
+func (p *fakePool) expectNone(method string) {
+	p.t.Helper()
+
+	timeout := time.After(time.Millisecond * 200)
+
+	for {
+		select {
+		case request := <-p.requests:
+			if request.Method == method {
+				p.t.Errorf("%s received %s %s", p.name, method, request.Params)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// fakeMiner is the miner's end of the session
+type fakeMiner struct {
+	t        *testing.T
+	conn     net.Conn
+	messages chan map[string]json.RawMessage
+}
+
+func newFakeMiner(t *testing.T, conn net.Conn) *fakeMiner {
+	miner := fakeMiner{
+		t:        t,
+		conn:     conn,
+		messages: make(chan map[string]json.RawMessage, 256),
+	}
+
+	go func() {
+		defer close(miner.messages)
+
+		scanner := bufio.NewScanner(conn)
+
+		for scanner.Scan() {
+			message := map[string]json.RawMessage{}
+			if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+				t.Errorf("miner received %s: %s", scanner.Bytes(), err)
+
+				return
+			}
+
+			miner.messages <- message
+		}
+	}()
+
+	return &miner
+}
+
+func (m *fakeMiner) send(id int, method string, params ...string) {
+	m.t.Helper()
+
+	data, err := json.Marshal(map[string]interface{}{"id": id, "method": method, "params": params})
+	if err != nil {
+		m.t.Fatal(err)
+	}
+
+	if _, err := m.conn.Write(append(data, '\n')); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+// expectResponse waits for the response of the id and returns its result
+func (m *fakeMiner) expectResponse(id int) string {
+	m.t.Helper()
+
+	timeout := time.After(testTimeout)
+
+	for {
+		select {
+		case message, ok := <-m.messages:
+			if !ok {
+				m.t.Fatalf("session closed before response %d", id)
+			}
+
+			if string(message["id"]) == fmt.Sprint(id) && message["method"] == nil {
+				return string(message["result"])
+			}
+		case <-timeout:
+			m.t.Fatalf("miner didn't receive response %d", id)
+		}
+	}
+}
+
+// expectJob waits for a notify of a job starting with the prefix and returns its id
+func (m *fakeMiner) expectJob(prefix string, poll func()) string {
+	m.t.Helper()
+
+	timeout := time.After(testTimeout)
+	ticker := time.NewTicker(time.Millisecond * 50)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-m.messages:
+			if !ok {
+				m.t.Fatalf("session closed before a job of %s", prefix)
+			}
+
+			if string(message["method"]) != `"`+stratum.MethodNiceHashNotify+`"` {
+				continue
+			}
+
+			params := make([]interface{}, 0)
+			if err := json.Unmarshal(message["params"], &params); err != nil || len(params) == 0 {
+				m.t.Fatalf("invalid notify %s", message["params"])
+			}
+
+			if job, _ := params[0].(string); strings.HasPrefix(job, prefix) {
+				return job
+			}
+		case <-ticker.C:
+			if poll != nil {
+				poll()
+			}
+		case <-timeout:
+			m.t.Fatalf("miner didn't receive a job of %s", prefix)
+		}
+	}
+}
+
+// startSession connects a miner to the origin through an extractor running in the background
+func startSession(t *testing.T, jobStore store.JobStore, origin string, option Option) (*fakeMiner, Extractor, chan error) {
+	t.Helper()
+
+	minerConn, localConn := net.Pipe()
+
+	extractor, err := New(jobStore, localConn, origin, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- extractor.Inject()
+	}()
+
+	t.Cleanup(func() {
+		_ = minerConn.Close()
+
+		extractor.Close()
+	})
+
+	return newFakeMiner(t, minerConn), extractor, done
+}
+
+func TestOriginRoutes(t *testing.T) {
+	origin := newFakePool(t, "origin")
+
+	miner, _, _ := startSession(t, store.NewMemoryJobStore(), origin.url, Option{})
+
+	// Miner to origin and back
+	miner.send(1, stratum.MethodNiceHashSubscribe, "miner/1.0", stratum.NiceHashProtocol)
+	origin.expect(stratum.MethodNiceHashSubscribe)
+
+	if result := miner.expectResponse(1); !strings.Contains(result, "origin") {
+		t.Errorf("unexpected subscribe result %s", result)
+	}
+
+	miner.send(2, stratum.MethodNiceHashAuthorize, "0xwallet.rig01", "x")
+
+	if request := origin.expect(stratum.MethodNiceHashAuthorize); !strings.Contains(string(request.Params), "0xwallet.rig01") {
+		t.Errorf("unexpected authorize %s", request.Params)
+	}
+
+	if result := miner.expectResponse(2); result != "true" {
+		t.Errorf("unexpected authorize result %s", result)
+	}
+
+	// Origin jobs reach the miner, their shares reach the origin
+	job := miner.expectJob("origin", func() {
+		origin.notify("origin-1")
+	})
+
+	miner.send(3, stratum.MethodNiceHashSubmit, "0xwallet.rig01", job, "0x1")
+
+	if request := origin.expect(stratum.MethodNiceHashSubmit); !strings.Contains(string(request.Params), job) {
+		t.Errorf("unexpected submit %s", request.Params)
+	}
+
+	if result := miner.expectResponse(3); result != "true" {
+		t.Errorf("unexpected submit result %s", result)
+	}
+
+	// Unknown methods pass through
+	miner.send(4, "mining.extranonce.subscribe")
+	origin.expect("mining.extranonce.subscribe")
+	miner.expectResponse(4)
+}
+
+func TestFeeRoutes(t *testing.T) {
+	origin := newFakePool(t, "origin")
+	inject := newFakePool(t, "inject")
+
+	miner, _, _ := startSession(t, store.NewMemoryJobStore(), origin.url, Option{
+		Router: "random",
+		Injects: []Destination{
+			{Route: JobInject, Pool: inject.url, Wallet: "0xfee", Worker: "feeworker", Weight: 1},
+		},
+	})
+
+	miner.send(1, stratum.MethodNiceHashSubscribe, "miner/1.0", stratum.NiceHashProtocol)
+	miner.expectResponse(1)
+
+	miner.send(2, stratum.MethodNiceHashAuthorize, "0xwallet.rig01", "x")
+	miner.expectResponse(2)
+
+	// The fee upstream logs in as the miner did, with the destination's wallet
+	inject.expect(stratum.MethodNiceHashSubscribe)
+
+	if request := inject.expect(stratum.MethodNiceHashAuthorize); !strings.Contains(string(request.Params), "0xfee.feeworker") {
+		t.Errorf("unexpected fee authorize %s", request.Params)
+	}
+
+	// Fee jobs reach the miner once the upstream is ready, their shares reach the fee pool only
+	feeJob := miner.expectJob("inject", func() {
+		inject.notify("inject-1")
+	})
+
+	miner.send(3, stratum.MethodNiceHashSubmit, "0xwallet.rig01", feeJob, "0x1")
+
+	if request := inject.expect(stratum.MethodNiceHashSubmit); !strings.Contains(string(request.Params), feeJob) {
+		t.Errorf("unexpected fee submit %s", request.Params)
+	}
+
+	if result := miner.expectResponse(3); result != "true" {
+		t.Errorf("unexpected fee submit result %s", result)
+	}
+
+	origin.expectNone(stratum.MethodNiceHashSubmit)
+
+	// Origin jobs still belong to the origin
+	originJob := miner.expectJob("origin", func() {
+		origin.notify("origin-1")
+	})
+
+	miner.send(4, stratum.MethodNiceHashSubmit, "0xwallet.rig01", originJob, "0x1")
+	origin.expect(stratum.MethodNiceHashSubmit)
+	miner.expectResponse(4)
+
+	inject.expectNone(stratum.MethodNiceHashSubmit)
+}
+
+func TestShutdown(t *testing.T) {
+	for _, closer := range []string{"miner", "extractor", "origin"} {
+		t.Run(closer, func(t *testing.T) {
+			origin := newFakePool(t, "origin")
+			inject := newFakePool(t, "inject")
+
+			miner, extractor, done := startSession(t, store.NewMemoryJobStore(), origin.url, Option{
+				Router: "random",
+				Injects: []Destination{
+					{Route: JobInject, Pool: inject.url, Wallet: "0xfee", Worker: "feeworker", Weight: 0.5},
+				},
+			})
+
+			miner.send(1, stratum.MethodNiceHashSubscribe, "miner/1.0", stratum.NiceHashProtocol)
+			miner.expectResponse(1)
+
+			miner.send(2, stratum.MethodNiceHashAuthorize, "0xwallet.rig01", "x")
+			miner.expectResponse(2)
+
+			inject.expect(stratum.MethodNiceHashAuthorize)
+
+			switch closer {
+			case "miner":
+				_ = miner.conn.Close()
+			case "extractor":
+				extractor.Close()
+			case "origin":
+				(<-origin.conns).Close()
+			}
+
+			select {
+			case <-done:
+			case <-time.After(testTimeout):
+				t.Fatal("the session didn't stop")
+			}
+
+			// Every upstream of the session is closed
+			for _, pool := range []*fakePool{origin, inject} {
+				select {
+				case <-pool.closed:
+				case <-time.After(testTimeout):
+					t.Errorf("%s connection wasn't closed", pool.name)
+				}
+			}
+		})
+	}
+}
+
+// hangingJobStore never answers, like a Redis that accepts connections and stops replying
+type hangingJobStore struct {
+	calls chan string
+}
+
+func (s *hangingJobStore) Set(ctx context.Context, key string, _ string, _ time.Duration) error {
+	s.calls <- "set " + key
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func (s *hangingJobStore) Get(ctx context.Context, keys ...string) (string, error) {
+	s.calls <- "get " + strings.Join(keys, " ")
+	<-ctx.Done()
+
+	return "", ctx.Err()
+}
+
+func TestDegradedStore(t *testing.T) {
+	origin := newFakePool(t, "origin")
+	inject := newFakePool(t, "inject")
+
+	jobStore := &hangingJobStore{calls: make(chan string, 64)}
+
+	miner, _, _ := startSession(t, jobStore, origin.url, Option{
+		Router: "random",
+		Injects: []Destination{
+			{Route: JobInject, Pool: inject.url, Wallet: "0xfee", Worker: "feeworker", Weight: 1},
+		},
+	})
+
+	miner.send(1, stratum.MethodNiceHashSubscribe, "miner/1.0", stratum.NiceHashProtocol)
+	miner.expectResponse(1)
+
+	miner.send(2, stratum.MethodNiceHashAuthorize, "0xwallet.rig01", "x")
+	miner.expectResponse(2)
+
+	inject.expect(stratum.MethodNiceHashAuthorize)
+
+	// A fee job hangs in the store once the upstream is ready, origin jobs keep flowing meanwhile
+	timeout := time.After(testTimeout)
+
+	for stored := false; !stored; {
+		inject.notify("inject-1")
+
+		select {
+		case call := <-jobStore.calls:
+			if !strings.HasPrefix(call, "set ") {
+				t.Fatalf("unexpected store call %s", call)
+			}
+
+			stored = true
+		case <-time.After(time.Millisecond * 50):
+		case <-timeout:
+			t.Fatal("the fee job wasn't stored")
+		}
+	}
+
+	startedAt := time.Now()
+
+	miner.expectJob("origin", func() {
+		origin.notify("origin-1")
+	})
+
+	if elapsed := time.Since(startedAt); elapsed > storeTimeout {
+		t.Errorf("origin jobs waited %s for the store", elapsed)
+	}
+
+	// Once the store failed, shares go to the origin without asking it
+	time.Sleep(storeTimeout + time.Millisecond*100)
+
+	startedAt = time.Now()
+
+	miner.send(3, stratum.MethodNiceHashSubmit, "0xwallet.rig01", "origin-1", "0x1")
+	origin.expect(stratum.MethodNiceHashSubmit)
+	miner.expectResponse(3)
+
+	if elapsed := time.Since(startedAt); elapsed > storeTimeout {
+		t.Errorf("the share waited %s for the store", elapsed)
+	}
+
+	select {
+	case call := <-jobStore.calls:
+		t.Errorf("the degraded store was called: %s", call)
+	default:
+	}
+
+	// Fee jobs are paused
+	inject.notify("inject-2")
+	inject.expectNone(stratum.MethodNiceHashSubmit)
+
+	select {
+	case call := <-jobStore.calls:
+		t.Errorf("the degraded store was called: %s", call)
+	default:
+	}
+}
+
+func TestFailedLookup(t *testing.T) {
+	origin := newFakePool(t, "origin")
+
+	jobStore := &hangingJobStore{calls: make(chan string, 64)}
+
+	miner, _, _ := startSession(t, jobStore, origin.url, Option{Router: "random"})
+
+	miner.send(1, stratum.MethodNiceHashAuthorize, "0xwallet.rig01", "x")
+	miner.expectResponse(1)
+
+	// The first lookup times out and marks the store degraded, the next share doesn't wait
+	miner.send(2, stratum.MethodNiceHashSubmit, "0xwallet.rig01", "origin-1", "0x1")
+	miner.expectResponse(2)
+	<-jobStore.calls
+
+	startedAt := time.Now()
+
+	miner.send(3, stratum.MethodNiceHashSubmit, "0xwallet.rig01", "origin-2", "0x1")
+	miner.expectResponse(3)
+
+	if elapsed := time.Since(startedAt); elapsed > storeTimeout/2 {
+		t.Errorf("the share waited %s for the degraded store", elapsed)
+	}
+
+	select {
+	case call := <-jobStore.calls:
+		t.Errorf("the degraded store was called: %s", call)
+	default:
+	}
+}
+
+func TestRuleWeights(t *testing.T) {
+	origin := newFakePool(t, "origin")
+
+	rules, err := rule.NewSet([]rule.Rule{
+		{Name: "internal", Wallet: "0xinternal", Weight: 0},
+		{Name: "partner", Wallet: "0xpartner", Weight: 0.015},
+		{Name: "small", Wallet: "0xsmall", Weight: 0.005},
+	}, 0.03)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A rule sets the total fee, the develop fee is taken from it first
+	for wallet, want := range map[string]map[string]float64{
+		"0xinternal": {JobDevelop: 0, JobInject: 0},
+		"0xpartner":  {JobDevelop: 0.01, JobInject: 0.005},
+		"0xsmall":    {JobDevelop: 0.005, JobInject: 0},
+		"0xother":    {JobDevelop: 0.01, JobInject: 0.02},
+	} {
+		minerConn, localConn := net.Pipe()
+
+		conn, err := New(store.NewMemoryJobStore(), localConn, origin.url, Option{
+			Rules:   rules,
+			Injects: []Destination{{Route: JobInject, Pool: "tcp://127.0.0.1:1", Weight: 0.02}},
+			Develop: &Destination{Pool: "tcp://127.0.0.1:1", Weight: 0.01},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := conn.(*extractor)
+
+		if err := e.applyRule(wallet, "rig01"); err != nil {
+			t.Fatal(err)
+		}
+
+		for route, weight := range want {
+			if math.Abs(e.weights[route]-weight) > 1e-9 {
+				t.Errorf("%s has %s weight %f, want %f", wallet, route, e.weights[route], weight)
+			}
+		}
+
+		e.Close()
+		_ = minerConn.Close()
+	}
+}
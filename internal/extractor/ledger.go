@@ -0,0 +1,127 @@
+package extractor
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+	"github.com/tier2pool/tier2pool/internal/ledger"
+	"github.com/tier2pool/tier2pool/internal/stratum"
+)
+
+// observeLogin remembers the wallet and worker the miner authorized as, for the ledger
+func (e *extractor) observeLogin(request jsonrpc.Request) (string, string) {
+	params := stratum.NiceHashAuthorizeParams{}
+	if err := json.Unmarshal(request.Params, &params); err != nil || len(params) == 0 {
+		return "", request.Worker
+	}
+
+	wallet, worker := params[0], request.Worker
+	if i := strings.Index(wallet, "."); i >= 0 {
+		wallet, worker = wallet[:i], wallet[i+1:]
+	}
+
+	e.ledgerLock.Lock()
+	defer e.ledgerLock.Unlock()
+
+	e.wallet, e.worker = wallet, worker
+
+	return wallet, worker
+}
+
+// recordSubmit starts a ledger entry of the share, it's recorded once the pool answers it
+func (e *extractor) recordSubmit(route string, id string, request jsonrpc.Request) {
+	if e.option.Ledger == nil {
+		return
+	}
+
+	difficulty := e.difficulty(route)
+
+	e.ledgerLock.Lock()
+	defer e.ledgerLock.Unlock()
+
+	share := ledger.Share{
+		Time:       time.Now().UTC(),
+		Session:    e.namespace.Session,
+		Wallet:     e.wallet,
+		Worker:     e.worker,
+		JobID:      id,
+		Route:      route,
+		Difficulty: difficulty,
+		Result:     ledger.ResultUnknown,
+	}
+
+	// Nothing will answer a share without id
+	if request.ID.IsNull() {
+		e.option.Ledger.Record(share)
+
+		return
+	}
+
+	if e.pendingCount >= maxPendingShares {
+		e.flushEntries()
+	}
+
+	// Miners may reuse an id before it's answered, a pool answers the shares of a connection in order
+	key := route + ":" + string(request.ID)
+
+	e.pendingEntries[key] = append(e.pendingEntries[key], share)
+	e.pendingCount++
+}
+
+// observeResult records the share the response answers
+func (e *extractor) observeResult(route string, header jsonrpc.Header, data []byte) {
+	if e.option.Ledger == nil {
+		return
+	}
+
+	e.ledgerLock.Lock()
+	defer e.ledgerLock.Unlock()
+
+	key := route + ":" + string(header.ID)
+
+	shares := e.pendingEntries[key]
+	if len(shares) == 0 {
+		return
+	}
+
+	share := shares[0]
+
+	if len(shares) == 1 {
+		delete(e.pendingEntries, key)
+	} else {
+		e.pendingEntries[key] = shares[1:]
+	}
+
+	e.pendingCount--
+
+	response := jsonrpc.Response{}
+	if err := json.Unmarshal(data, &response); err == nil {
+		share.Result = ledger.ResultRejected
+
+		accepted := false
+		if response.Error == nil && json.Unmarshal(response.Result, &accepted) == nil && accepted {
+			share.Result = ledger.ResultAccepted
+		}
+	}
+
+	e.option.Ledger.Record(share)
+}
+
+// flushEntries records the unanswered shares, the caller must hold the ledger lock
+func (e *extractor) flushEntries() {
+	if e.option.Ledger == nil {
+		return
+	}
+
+	for key, shares := range e.pendingEntries {
+		for _, share := range shares {
+			e.option.Ledger.Record(share)
+		}
+
+		delete(e.pendingEntries, key)
+	}
+
+	e.pendingCount = 0
+}
@@ -0,0 +1,66 @@
+package extractor
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+	"github.com/tier2pool/tier2pool/internal/ledger"
+)
+
+func TestReusedShareIDs(t *testing.T) {
+	option := ledger.Option{
+		Sink: ledger.SinkFile,
+		Path: filepath.Join(t.TempDir(), "shares.log"),
+	}
+
+	shareLedger, err := ledger.New(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go shareLedger.Run(ctx)
+
+	e := extractor{
+		option:         Option{Ledger: shareLedger},
+		states:         make(map[string]*routeState),
+		pendingEntries: make(map[string][]ledger.Share),
+	}
+
+	// The second share reuses the id of the first one before the pool answered it
+	request := jsonrpc.Request{ID: jsonrpc.NewID(4), Method: "mining.submit"}
+	e.recordSubmit(JobOrigin, "job1", request)
+	e.recordSubmit(JobOrigin, "job2", request)
+
+	header := jsonrpc.Header{ID: jsonrpc.NewID(4)}
+	e.observeResult(JobOrigin, header, []byte(`{"id":4,"result":true,"error":null}`))
+	e.observeResult(JobOrigin, header, []byte(`{"id":4,"result":false,"error":null}`))
+
+	if e.pendingCount != 0 || len(e.pendingEntries) != 0 {
+		t.Errorf("%d shares are still pending", e.pendingCount)
+	}
+
+	cancel()
+
+	select {
+	case <-shareLedger.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("ledger didn't finish")
+	}
+
+	results := make(map[string]string)
+	if err := ledger.Read(option, time.Time{}, time.Time{}, func(share ledger.Share) error {
+		results[share.JobID] = share.Result
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if results["job1"] != ledger.ResultAccepted || results["job2"] != ledger.ResultRejected {
+		t.Errorf("recorded %v, want job1 accepted and job2 rejected", results)
+	}
+}
@@ -0,0 +1,74 @@
+package extractor
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/tier2pool/tier2pool/internal/stratum"
+)
+
+func TestMatchPrefix(t *testing.T) {
+	tests := []struct {
+		login, prefix string
+		matched       bool
+	}{
+		{"0xabc.rig01", "0xabc.rig01", true},
+		{"0xabc.rig01", "0xabc", true},
+		{"0xabc.rig01.gpu0", "0xabc.rig01", true},
+		{"0xabc/rig01", "0xabc", true},
+		{"0xabc.rig010", "0xabc.rig01", false},
+		{"0xabcd.rig01", "0xabc", false},
+		{"0xabc.rig01", "0xabc.", true},
+		{"0xab", "0xabc", false},
+	}
+
+	for _, test := range tests {
+		if matched := matchPrefix(test.login, test.prefix); matched != test.matched {
+			t.Errorf("matchPrefix(%q, %q) = %v, want %v", test.login, test.prefix, matched, test.matched)
+		}
+	}
+}
+
+func TestCheckLogin(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		params  string
+		allowed bool
+	}{
+		{"authorize", stratum.MethodNiceHashAuthorize, `["0xabc.rig01","x"]`, true},
+		{"authorize of another rig", stratum.MethodNiceHashAuthorize, `["0xabc.rig010","x"]`, false},
+		{"openpool login", stratum.MethodOpenPoolSubmitLogin, `["0xabc.rig01"]`, true},
+		{"openpool login of another wallet", stratum.MethodOpenPoolSubmitLogin, `["0xdef.rig01"]`, false},
+		{"monero login", stratum.MethodMoneroLogin, `{"login":"0xabc.rig01","pass":"x"}`, true},
+		{"monero login of another wallet", stratum.MethodMoneroLogin, `{"login":"0xdef","pass":"x"}`, false},
+		{"unknown method before login", "eth_getWork", `[]`, false},
+		{"subscribe before login", stratum.MethodNiceHashSubscribe, `[]`, true},
+	}
+
+	for _, test := range tests {
+		e := &extractor{option: Option{Prefixes: []string{"0xabc.rig01"}}}
+
+		data, _ := json.Marshal(map[string]any{"id": 1, "method": test.method, "params": json.RawMessage(test.params)})
+
+		err := e.checkLogin(test.method, data)
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("%s: allowed = %v, want %v (%v)", test.name, allowed, test.allowed, err)
+		}
+
+		if err != nil && !errors.Is(err, ErrWorkerNotAllowed) {
+			t.Errorf("%s: %v isn't ErrWorkerNotAllowed", test.name, err)
+		}
+	}
+
+	// Once logged in, the other methods pass
+	e := &extractor{option: Option{Prefixes: []string{"0xabc"}}}
+	if err := e.checkLogin(stratum.MethodOpenPoolSubmitLogin, []byte(`{"id":1,"method":"eth_submitLogin","params":["0xabc"]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.checkLogin("eth_getWork", []byte(`{"id":2,"method":"eth_getWork","params":[]}`)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,94 @@
+package extractor
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+)
+
+const (
+	// Messages waiting to be written to a connection, a full outbox blocks the sender
+	outboxSize = 64
+)
+
+// peer is one connection of a session, it's only written by its own writer goroutine
+type peer struct {
+	conn         jsonrpc.Conn
+	outbox       chan []byte
+	logOutbound  string
+	writeTimeout time.Duration
+	readTimeout  time.Duration
+}
+
+// send queues a copy of the data, blocking while the outbox is full so a slow peer slows the session down
+func (p *peer) send(ctx context.Context, data []byte) error {
+	message := make([]byte, len(data))
+	copy(message, data)
+
+	select {
+	case p.outbox <- message:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *peer) write(ctx context.Context) error {
+	for {
+		select {
+		case message := <-p.outbox:
+			if err := p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout)); err != nil {
+				return err
+			}
+
+			if _, err := p.conn.Write(message); err != nil {
+				return err
+			}
+
+			logrus.Debug(p.logOutbound, string(message))
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// read passes each line to the handler, the line is only valid until the handler returns
+func (p *peer) read(maxLineLength int, handler func(data []byte) error) error {
+	decoder := jsonrpc.NewDecoder(p.conn, maxLineLength)
+	defer decoder.Release()
+
+	for {
+		if err := p.conn.SetReadDeadline(time.Now().Add(p.readTimeout)); err != nil {
+			return err
+		}
+
+		data, err := decoder.ReadLine()
+		if err != nil {
+			return err
+		}
+
+		if err := handler(data); err != nil {
+			return err
+		}
+	}
+}
+
+func newPeer(conn jsonrpc.Conn, logOutbound string, option Option) *peer {
+	return &peer{
+		conn:         conn,
+		outbox:       make(chan []byte, outboxSize),
+		logOutbound:  logOutbound,
+		writeTimeout: secondsOrDefault(option.Timeout, DefaultTimeout),
+		readTimeout:  secondsOrDefault(option.IdleTimeout, DefaultIdleTimeout),
+	}
+}
+
+func secondsOrDefault(second int, defaultValue time.Duration) time.Duration {
+	if second == 0 {
+		return defaultValue
+	}
+
+	return time.Second * time.Duration(second)
+}
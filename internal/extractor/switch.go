@@ -0,0 +1,420 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+	"github.com/tier2pool/tier2pool/internal/metrics"
+	"github.com/tier2pool/tier2pool/internal/router"
+	"github.com/tier2pool/tier2pool/internal/stratum"
+)
+
+const (
+	// How often a scheduling router is asked whether the miner should switch
+	switchInterval = time.Second
+
+	// A job older than this isn't resent on a switch, the miner waits for the next one instead
+	maxJobAge = time.Minute
+
+	// Fee jobs are paused for so long after the job store failed
+	degradedInterval = time.Second * 30
+
+	// A job store call taking longer than this fails, so that a store that stopped answering can't stall the session
+	storeTimeout = time.Millisecond * 500
+)
+
+// routeState is what the miner has to be told before working on the jobs of a route
+type routeState struct {
+	difficulty    float64
+	setDifficulty []byte
+	setExtranonce []byte
+	notify        []byte
+	notifiedAt    time.Time
+}
+
+// state returns the state of the route, the caller must hold the switch lock
+func (e *extractor) state(route string) *routeState {
+	state, ok := e.states[route]
+	if !ok {
+		state = &routeState{}
+		e.states[route] = state
+	}
+
+	return state
+}
+
+// resetState forgets what an upstream of the route told
+func (e *extractor) resetState(route string) {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	delete(e.states, route)
+}
+
+// forward passes a job message of the route to the miner, messages of a route the miner
+// isn't working for are only remembered until the session switches to it
+func (e *extractor) forward(ctx context.Context, route string, method string, data []byte) error {
+	if method == stratum.MethodNiceHashNotify && route != JobOrigin {
+		return e.forwardJob(ctx, route, data)
+	}
+
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	state := e.state(route)
+
+	switch method {
+	case stratum.MethodNiceHashSetDifficulty:
+		state.setDifficulty = clone(data)
+		state.difficulty = parseDifficulty(data)
+	case stratum.MethodNiceHashSetExtranonce:
+		state.setExtranonce = clone(data)
+	case stratum.MethodNiceHashNotify:
+		state.notify = clone(data)
+		state.notifiedAt = time.Now()
+
+		// The miner keeps working on the jobs of the current route while the router holds these back
+		if !e.accept(route) {
+			return nil
+		}
+
+		if route != e.active {
+			if err := e.switchTo(ctx, route); err != nil {
+				return err
+			}
+		}
+
+		return e.localPeer.send(ctx, data)
+	}
+
+	if route != e.active {
+		return nil
+	}
+
+	return e.localPeer.send(ctx, data)
+}
+
+// forwardJob passes a job of a fee route once its route is stored, the store is called without the switch lock
+// so that a slow store only holds back the jobs of the route, never the origin's
+func (e *extractor) forwardJob(ctx context.Context, route string, data []byte) error {
+	e.switchLock.Lock()
+
+	state := e.state(route)
+	state.notify = clone(data)
+	state.notifiedAt = time.Now()
+
+	accepted := e.accept(route)
+
+	e.switchLock.Unlock()
+
+	if !accepted || !e.rememberJob(ctx, route, data) {
+		return nil
+	}
+
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	// The window of the route may have closed while the job was stored
+	if scheduler, ok := e.router.(router.Scheduler); ok && scheduler.Current() != route {
+		return nil
+	}
+
+	if route != e.active {
+		if err := e.switchTo(ctx, route); err != nil {
+			return err
+		}
+	}
+
+	return e.localPeer.send(ctx, data)
+}
+
+// switchTo sends the difficulty and extranonce of the route ahead of its jobs, the caller must hold the switch lock
+func (e *extractor) switchTo(ctx context.Context, route string) error {
+	state := e.state(route)
+
+	for _, data := range [][]byte{state.setExtranonce, state.setDifficulty} {
+		if data == nil {
+			continue
+		}
+
+		if err := e.localPeer.send(ctx, data); err != nil {
+			return err
+		}
+	}
+
+	logrus.Debugf("%s switched from %s to %s", e.localPeer.conn.RemoteAddr(), e.active, route)
+
+	metrics.RouteSwitches.WithLabelValues(route).Inc()
+
+	e.active = route
+
+	return nil
+}
+
+// schedule switches the miner as soon as the router's current route changes,
+// the latest job of the route is resent with clean jobs so the miner drops the previous work at once
+func (e *extractor) schedule(ctx context.Context) error {
+	ticker := time.NewTicker(switchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+
+		if err := e.reschedule(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *extractor) reschedule(ctx context.Context) error {
+	route, data, err := e.nextSwitch(ctx)
+	if err != nil || data == nil {
+		return err
+	}
+
+	if !e.rememberJob(ctx, route, data) {
+		return nil
+	}
+
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	// A job of the route may have switched the miner already, or its window closed while the job was stored
+	closed := route != JobOrigin && e.router.(router.Scheduler).Current() != route
+	if route == e.active || closed {
+		return nil
+	}
+
+	if err := e.switchTo(ctx, route); err != nil {
+		return err
+	}
+
+	return e.localPeer.send(ctx, data)
+}
+
+// nextSwitch returns the route the miner should switch to with its latest job, nil data means no switch
+func (e *extractor) nextSwitch(ctx context.Context) (string, []byte, error) {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	scheduler, ok := e.router.(router.Scheduler)
+	if !ok {
+		return "", nil, nil
+	}
+
+	route := scheduler.Current()
+
+	// Upstreams are opened ahead of their window, so that logging in doesn't take from the time of their route
+	upcoming := scheduler.Upcoming(openAhead)
+	for _, next := range upcoming {
+		e.openRoute(ctx, next)
+	}
+
+	// The miner stays on the origin pool until the upstream of the route has logged in
+	if route != JobOrigin && !e.ready(route) {
+		e.openRoute(ctx, route)
+
+		route = JobOrigin
+	}
+
+	e.closeIdle(append(upcoming, route)...)
+
+	if route == e.active || !e.accept(route) {
+		return "", nil, nil
+	}
+
+	// Without a fresh job the switch happens on the next notify of the route
+	state := e.state(route)
+	if state.notify == nil || time.Since(state.notifiedAt) > maxJobAge {
+		return "", nil, nil
+	}
+
+	data, err := cleanJobs(state.notify)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return route, data, nil
+}
+
+// accept asks the router unless the job store is failing, then only origin jobs are worked on
+// because the shares of a fee job couldn't be routed back to its pool, the caller must hold the switch lock.
+// Before the miner authorized there's no router, nor a fee.
+// Jobs of a fee upstream are only accepted once it has logged in, and the origin pool's jobs aren't
+// held back for a route whose upstream isn't ready
+func (e *extractor) accept(route string) bool {
+	if e.router == nil || time.Since(e.storeFailedAt) < degradedInterval {
+		return route == JobOrigin
+	}
+
+	if route != JobOrigin {
+		return e.ready(route) && e.router.Accept(route)
+	}
+
+	if e.router.Accept(JobOrigin) {
+		return true
+	}
+
+	for _, up := range e.fees {
+		if e.ready(up.Route) && e.router.Accept(up.Route) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rememberJob stores the route of a fee job, so that its shares are submitted to its pool,
+// it reports false when the job can't be sent to the miner, the caller must not hold the switch lock
+func (e *extractor) rememberJob(ctx context.Context, route string, data []byte) bool {
+	if route == JobOrigin {
+		return true
+	}
+
+	request := jsonrpc.Notification{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return false
+	}
+
+	params := stratum.NiceHashNotifyParams{}
+	if err := json.Unmarshal(request.Params, &params); err != nil || len(params) == 0 {
+		return false
+	}
+
+	id, ok := params[0].(string)
+	if !ok {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	if err := e.jobStore.Set(ctx, e.namespace.Key(route, id), route, jobTTL); err != nil {
+		e.storeFailed(fmt.Errorf("failed to store job %s: %w", id, err))
+
+		return false
+	}
+
+	return true
+}
+
+// storeFailed pauses the fee jobs for a while, shares are submitted to the origin pool meanwhile
+func (e *extractor) storeFailed(err error) {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	if time.Since(e.storeFailedAt) >= degradedInterval {
+		logrus.Warnf("fee jobs are paused, %s", err)
+	}
+
+	e.storeFailedAt = time.Now()
+}
+
+// degraded reports whether the job store failed recently
+func (e *extractor) degraded() bool {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	return time.Since(e.storeFailedAt) < degradedInterval
+}
+
+// observeSubscribe turns the subscribe result of an upstream into a set_extranonce,
+// so that the miner can be given the extranonce of whichever pool it's working for
+func (e *extractor) observeSubscribe(route string, header jsonrpc.Header, data []byte) {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	if e.subscribeID == "" || string(header.ID) != e.subscribeID {
+		return
+	}
+
+	response := jsonrpc.Response{}
+	if err := json.Unmarshal(data, &response); err != nil || response.Error != nil {
+		return
+	}
+
+	// The subscriptions come first, the extranonce and its size follow
+	result := stratum.NiceHashSubscribeResult{}
+	if err := json.Unmarshal(response.Result, &result); err != nil || len(result) < 2 {
+		return
+	}
+
+	params, err := json.Marshal(result[1:])
+	if err != nil {
+		return
+	}
+
+	setExtranonce, err := json.Marshal(jsonrpc.Notification{
+		JSONRPC: response.JSONRPC,
+		Method:  stratum.MethodNiceHashSetExtranonce,
+		Params:  params,
+	})
+	if err != nil {
+		return
+	}
+
+	e.state(route).setExtranonce = setExtranonce
+}
+
+// difficulty returns the last difficulty of the job's pool, zero if the pool never set it
+func (e *extractor) difficulty(job string) float64 {
+	e.switchLock.Lock()
+	defer e.switchLock.Unlock()
+
+	return e.state(job).difficulty
+}
+
+func parseDifficulty(data []byte) float64 {
+	request := jsonrpc.Notification{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return 0
+	}
+
+	params := stratum.NiceHashSetDifficultyParams{}
+	if err := json.Unmarshal(request.Params, &params); err != nil || len(params) == 0 {
+		return 0
+	}
+
+	return params[0]
+}
+
+// cleanJobs sets the trailing clean jobs flag of a notify, other parameters are kept byte for byte
+func cleanJobs(data []byte) ([]byte, error) {
+	request := jsonrpc.Notification{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+
+	params := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(request.Params, &params); err != nil {
+		return nil, err
+	}
+
+	if len(params) == 0 || !bytes.Equal(params[len(params)-1], []byte("false")) {
+		return data, nil
+	}
+
+	params[len(params)-1] = json.RawMessage("true")
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Params = rawParams
+
+	return json.Marshal(request)
+}
+
+func clone(data []byte) []byte {
+	return append([]byte(nil), data...)
+}
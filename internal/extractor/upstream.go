@@ -0,0 +1,269 @@
+package extractor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+	"golang.org/x/sync/errgroup"
+)
+
+// Destination is a fee pool the miner's work can be routed to
+type Destination struct {
+	// Route names the destination in routing, accounting and the ledger, it must be unique in a session
+	Route  string
+	Pool   string
+	Wallet string
+	// Worker is the worker name the miner is authorized as on the pool
+	Worker string
+	Weight float64
+}
+
+// upstream is the connection of a session to a destination, it's only opened once the destination is needed
+// and its jobs are only routed to the miner once it has accepted the miner's subscribe and authorize
+type upstream struct {
+	Destination
+
+	peer     *peer
+	opening  bool
+	ready    bool
+	cancel   context.CancelFunc
+	failedAt time.Time
+	activeAt time.Time
+}
+
+// fee returns the upstream of the route, nil if there's none
+func (e *extractor) fee(route string) *upstream {
+	for _, up := range e.fees {
+		if up.Route == route {
+			return up
+		}
+	}
+
+	return nil
+}
+
+// feePeer returns the peer of the route, nil until the upstream is open
+func (e *extractor) feePeer(route string) *peer {
+	e.upstreamLock.Lock()
+	defer e.upstreamLock.Unlock()
+
+	if up := e.fee(route); up != nil {
+		return up.peer
+	}
+
+	return nil
+}
+
+const (
+	// An upstream that failed isn't opened again for so long
+	reopenInterval = time.Second * 30
+
+	// An upstream the miner hasn't worked for in so long is closed, shares of its jobs are still answered until then
+	idleUpstream = jobTTL
+
+	// A scheduled upstream is opened so long before its window, enough to dial, log in and receive a job
+	openAhead = time.Second * 30
+)
+
+// ready reports whether the upstream of the route has logged in
+func (e *extractor) ready(route string) bool {
+	e.upstreamLock.Lock()
+	defer e.upstreamLock.Unlock()
+
+	up := e.fee(route)
+
+	return up != nil && up.ready
+}
+
+// openRoute opens the upstream of the route if there's one
+func (e *extractor) openRoute(ctx context.Context, route string) {
+	if up := e.fee(route); up != nil {
+		e.open(ctx, up)
+	}
+}
+
+// closeIdle closes the upstreams that are neither in use, about to be, nor were worked for recently
+func (e *extractor) closeIdle(routes ...string) {
+	e.upstreamLock.Lock()
+	defer e.upstreamLock.Unlock()
+
+	for _, up := range e.fees {
+		used := up.Route == e.active
+		for _, route := range routes {
+			used = used || up.Route == route
+		}
+
+		if used {
+			up.activeAt = time.Now()
+
+			continue
+		}
+
+		if up.cancel != nil && time.Since(up.activeAt) > idleUpstream {
+			logrus.Debugf("%s of %s is idle", up.Route, e.localPeer.conn.RemoteAddr())
+
+			up.cancel()
+			up.cancel = nil
+		}
+	}
+}
+
+// observeHandshake marks the upstream ready once it accepted the authorize, and closes it if it refused
+// the subscribe or the authorize, it's opened again after a while
+func (e *extractor) observeHandshake(route string, header jsonrpc.Header, data []byte) {
+	e.switchLock.Lock()
+	subscribeID := e.subscribeID
+	e.switchLock.Unlock()
+
+	e.upstreamLock.Lock()
+	defer e.upstreamLock.Unlock()
+
+	up := e.fee(route)
+	if up == nil || up.ready || e.authorizeRequest == nil {
+		return
+	}
+
+	id := string(header.ID)
+	if id != subscribeID && id != string(e.authorizeRequest.ID) {
+		return
+	}
+
+	response := jsonrpc.Response{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return
+	}
+
+	accepted := response.Error == nil
+	if accepted && id == string(e.authorizeRequest.ID) {
+		if err := json.Unmarshal(response.Result, &accepted); err != nil {
+			accepted = false
+		}
+	}
+
+	if !accepted {
+		logrus.Warnf("%s refused %s of %s", up.Pool, up.Route, e.localPeer.conn.RemoteAddr())
+
+		up.failedAt = time.Now()
+
+		if up.cancel != nil {
+			up.cancel()
+			up.cancel = nil
+		}
+
+		return
+	}
+
+	if id == string(e.authorizeRequest.ID) {
+		up.ready = true
+		up.activeAt = time.Now()
+
+		logrus.Debugf("%s of %s is ready", up.Route, e.localPeer.conn.RemoteAddr())
+	}
+}
+
+// open connects the upstream in the background and logs in as the miner did, with the destination's wallet,
+// a destination that can't be reached or drops the connection only takes its route out of the session
+func (e *extractor) open(ctx context.Context, up *upstream) {
+	e.upstreamLock.Lock()
+	defer e.upstreamLock.Unlock()
+
+	if up.peer != nil || up.opening || time.Since(up.failedAt) < reopenInterval {
+		return
+	}
+
+	up.opening = true
+	up.activeAt = time.Now()
+
+	e.group.Go(func() error {
+		err := e.run(ctx, up)
+
+		e.upstreamLock.Lock()
+		up.peer, up.opening, up.ready, up.cancel = nil, false, false, nil
+		e.upstreamLock.Unlock()
+
+		if err != nil && ctx.Err() == nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) &&
+			!errors.Is(err, context.Canceled) {
+			logrus.Warnf("%s of %s is unavailable: %s", up.Route, e.localPeer.conn.RemoteAddr(), err)
+
+			e.upstreamLock.Lock()
+			up.failedAt = time.Now()
+			e.upstreamLock.Unlock()
+		}
+
+		return nil
+	})
+}
+
+func (e *extractor) run(ctx context.Context, up *upstream) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// What the previous connection told is stale
+	e.resetState(up.Route)
+
+	conn, err := e.option.Dialer.DialContext(ctx, up.Pool)
+	if err != nil {
+		return err
+	}
+
+	p := newPeer(conn, logPrefix("->", up.Route), e.option)
+
+	e.upstreamLock.Lock()
+	up.peer, up.cancel = p, cancel
+	subscribeData, authorizeRequest := e.subscribeData, e.authorizeRequest
+	e.upstreamLock.Unlock()
+
+	eg, upstreamCtx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		<-upstreamCtx.Done()
+
+		return conn.Close()
+	})
+
+	eg.Go(func() error {
+		return p.write(upstreamCtx)
+	})
+
+	eg.Go(func() error {
+		return p.read(e.option.MaxLineLength, func(data []byte) error {
+			return e.handleNotify(upstreamCtx, data, up.Route)
+		})
+	})
+
+	if subscribeData != nil {
+		if err := p.send(upstreamCtx, subscribeData); err != nil {
+			return err
+		}
+	}
+
+	if authorizeRequest != nil {
+		authorizeData, err := authorizeAs(*authorizeRequest, up.Wallet, up.Worker)
+		if err != nil {
+			return err
+		}
+
+		if err := p.send(upstreamCtx, authorizeData); err != nil {
+			return err
+		}
+	}
+
+	return eg.Wait()
+}
+
+// logPrefix returns the debug prefix of the route, like -> Inject
+func logPrefix(direction, route string) string {
+	if route == "" {
+		return direction
+	}
+
+	return fmt.Sprintf("%s %s%s", direction, strings.ToUpper(route[:1]), route[1:])
+}
@@ -0,0 +1,297 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrClientClosed = errors.New("client is closed")
+)
+
+// Handler receives the messages which are not a response to a call,
+// they are a *Request, *Notification or *Response with an unknown id
+type Handler func(message interface{})
+
+// Future is a call waiting for its response
+type Future struct {
+	ID       ID
+	client   *Client
+	done     chan struct{}
+	response *Response
+	err      error
+}
+
+// Wait blocks until the response arrives, the context is done or the client is closed,
+// a response carrying an error object returns it as a *Error
+func (f *Future) Wait(ctx context.Context) (*Response, error) {
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+
+		if f.response.Error != nil {
+			return f.response, f.response.Error
+		}
+
+		return f.response, nil
+	case <-ctx.Done():
+		f.client.forget(f.ID)
+
+		return nil, ctx.Err()
+	}
+}
+
+// Client correlates requests with responses over a Conn, it owns reading from the connection
+type Client struct {
+	conn          Conn
+	handler       Handler
+	maxLineLength int
+	nextID        uint64
+	pending       map[string]*Future
+	pendingLocker sync.Mutex
+	writeLocker   sync.Mutex
+	done          chan struct{}
+	err           error
+}
+
+// Go sends the request and returns without waiting for the response
+func (c *Client) Go(ctx context.Context, method string, params interface{}) (*Future, error) {
+	paramsData, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	id := NewID(int(atomic.AddUint64(&c.nextID, 1)))
+
+	request := Request{
+		ID:     id,
+		Method: method,
+		Params: paramsData,
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	future := Future{
+		ID:     id,
+		client: c,
+		done:   make(chan struct{}),
+	}
+
+	c.pendingLocker.Lock()
+
+	// The connection is already broken
+	if c.pending == nil {
+		err := c.err
+		if err == nil {
+			err = ErrClientClosed
+		}
+
+		c.pendingLocker.Unlock()
+
+		return nil, err
+	}
+
+	c.pending[string(id)] = &future
+
+	c.pendingLocker.Unlock()
+
+	if err := c.write(ctx, data); err != nil {
+		c.forget(id)
+
+		return nil, err
+	}
+
+	return &future, nil
+}
+
+// Call sends the request and waits for its response
+func (c *Client) Call(ctx context.Context, method string, params interface{}) (*Response, error) {
+	future, err := c.Go(ctx, method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return future.Wait(ctx)
+}
+
+// Notify sends a notification without id
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+	paramsData, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(Notification{
+		Method: method,
+		Params: paramsData,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.write(ctx, data)
+}
+
+// write sends the data before the context is done, a write interrupted by the context closes the connection
+// because a partial line would corrupt the stream
+func (c *Client) write(ctx context.Context, data []byte) error {
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	// Expiring the deadline is the only way to interrupt a blocked write, the next write
+	// only sets its own deadline once this goroutine is gone
+	done := make(chan struct{})
+	interrupted := make(chan struct{})
+
+	go func() {
+		defer close(interrupted)
+
+		select {
+		case <-ctx.Done():
+			_ = c.conn.SetWriteDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	_, err := c.conn.Write(data)
+
+	close(done)
+	<-interrupted
+
+	if err != nil && ctx.Err() != nil {
+		_ = c.conn.Close()
+
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (c *Client) forget(id ID) {
+	c.pendingLocker.Lock()
+	defer c.pendingLocker.Unlock()
+
+	delete(c.pending, string(id))
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Done is closed when the connection is broken
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (c *Client) read() {
+	decoder := NewDecoder(c.conn, c.maxLineLength)
+	defer decoder.Release()
+
+	var err error
+
+	for {
+		var data []byte
+
+		if data, err = decoder.ReadLine(); err != nil {
+			break
+		}
+
+		var message interface{}
+
+		if message, err = Unmarshal(data); err != nil {
+			break
+		}
+
+		response, ok := message.(*Response)
+		if !ok {
+			c.handle(message)
+
+			continue
+		}
+
+		c.pendingLocker.Lock()
+
+		future, ok := c.pending[string(response.ID)]
+		delete(c.pending, string(response.ID))
+
+		c.pendingLocker.Unlock()
+
+		if !ok {
+			c.handle(message)
+
+			continue
+		}
+
+		future.response = response
+		close(future.done)
+	}
+
+	_ = c.conn.Close()
+
+	c.pendingLocker.Lock()
+
+	// Fail all calls still waiting
+	for _, future := range c.pending {
+		future.err = err
+		close(future.done)
+	}
+
+	c.pending = nil
+	c.err = err
+
+	c.pendingLocker.Unlock()
+
+	close(c.done)
+}
+
+func (c *Client) handle(message interface{}) {
+	if c.handler != nil {
+		c.handler(message)
+	}
+}
+
+// NewClient starts reading from the connection, the handler is called from the reading goroutine
+func NewClient(conn Conn, handler Handler, maxLineLength int) *Client {
+	client := Client{
+		conn:          conn,
+		handler:       handler,
+		maxLineLength: maxLineLength,
+		pending:       make(map[string]*Future),
+		done:          make(chan struct{}),
+	}
+
+	// Nothing waits for a read deadline, calls carry their own timeout
+	_ = conn.SetReadDeadline(time.Time{})
+
+	go client.read()
+
+	return &client
+}
@@ -0,0 +1,175 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// newPipeClient returns a client and the pool's end of the connection
+func newPipeClient(t *testing.T, handler Handler) (*Client, net.Conn) {
+	t.Helper()
+
+	clientConn, poolConn := net.Pipe()
+
+	client := NewClient(New(clientConn), handler, 0)
+
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = poolConn.Close()
+	})
+
+	return client, poolConn
+}
+
+// answer reads one request and writes the response built from its id
+func answer(t *testing.T, poolConn net.Conn, reader *bufio.Reader, response func(id string) string) {
+	t.Helper()
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	request := Request{}
+	if err := json.Unmarshal(line, &request); err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	if _, err := fmt.Fprintln(poolConn, response(string(request.ID))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientCall(t *testing.T) {
+	messages := make(chan interface{}, 1)
+
+	client, poolConn := newPipeClient(t, func(message interface{}) {
+		messages <- message
+	})
+
+	go func() {
+		reader := bufio.NewReader(poolConn)
+
+		answer(t, poolConn, reader, func(id string) string {
+			// A notification arriving before the response goes to the handler
+			return `{"id":null,"method":"mining.set_difficulty","params":[2]}` + "\n" +
+				fmt.Sprintf(`{"id":%s,"result":true,"error":null}`, id)
+		})
+	}()
+
+	response, err := client.Call(context.Background(), "mining.subscribe", []string{"miner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(response.Result) != "true" {
+		t.Errorf("expected true, got %s", response.Result)
+	}
+
+	select {
+	case message := <-messages:
+		if notification, ok := message.(*Notification); !ok || notification.Method != "mining.set_difficulty" {
+			t.Errorf("unexpected message %#v", message)
+		}
+	case <-time.After(time.Second):
+		t.Error("the notification wasn't handled")
+	}
+}
+
+func TestClientErrorResponse(t *testing.T) {
+	client, poolConn := newPipeClient(t, nil)
+
+	go func() {
+		answer(t, poolConn, bufio.NewReader(poolConn), func(id string) string {
+			return fmt.Sprintf(`{"id":%s,"result":null,"error":[20,"Other/Unknown",null]}`, id)
+		})
+	}()
+
+	_, err := client.Call(context.Background(), "mining.subscribe", nil)
+
+	var rpcError *Error
+	if !errors.As(err, &rpcError) || rpcError.Code != 20 {
+		t.Errorf("expected error 20, got %v", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	client, poolConn := newPipeClient(t, nil)
+
+	// The pool reads the request and never answers
+	go func() {
+		_, _ = bufio.NewReader(poolConn).ReadBytes('\n')
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if _, err := client.Call(ctx, "mining.subscribe", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	client.pendingLocker.Lock()
+	pending := len(client.pending)
+	client.pendingLocker.Unlock()
+
+	if pending != 0 {
+		t.Errorf("%d calls are still pending", pending)
+	}
+}
+
+func TestClientClosed(t *testing.T) {
+	client, poolConn := newPipeClient(t, nil)
+
+	go func() {
+		_, _ = bufio.NewReader(poolConn).ReadBytes('\n')
+		_ = poolConn.Close()
+	}()
+
+	// The call waiting for its response fails once the connection is broken
+	if _, err := client.Call(context.Background(), "mining.subscribe", nil); err == nil {
+		t.Error("expected an error")
+	}
+
+	<-client.Done()
+
+	if _, err := client.Go(context.Background(), "mining.subscribe", nil); err == nil {
+		t.Error("expected an error after the connection is broken")
+	}
+}
+
+func TestClientWriteCancel(t *testing.T) {
+	client, _ := newPipeClient(t, nil)
+
+	// Nobody reads the pipe, the write blocks until the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		cancel()
+	}()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- client.Notify(ctx, "mining.extranonce.subscribe", []string{})
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the write ignored the cancellation")
+	}
+}
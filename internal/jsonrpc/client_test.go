@@ -0,0 +1,115 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed certificate of the host, it's its own root
+func newTestCertificate(t *testing.T, host string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, certPool
+}
+
+func TestDialWebSocketSRV(t *testing.T) {
+	certificate, certPool := newTestCertificate(t, "localhost")
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wsListener := ListenWebSocket(listener, "/")
+	defer wsListener.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	// The certificate is issued for the target, not for the record name
+	resolver := Resolver{
+		Resolver: &stubResolver{
+			srv: map[string][]*net.SRV{
+				"_stratum._tcp.pool.example": {{Target: "localhost.", Port: uint16(portNumber)}},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	accepted := make(chan net.Conn, 1)
+
+	go func() {
+		if conn, err := wsListener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := DialContext(ctx, "srv+wss://_stratum._tcp.pool.example/", Option{
+		TLS:      &tls.Config{RootCAs: certPool},
+		Resolver: &resolver,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case serverConn := <-accepted:
+		defer serverConn.Close()
+
+		line, err := bufio.NewReader(serverConn).ReadString('\n')
+		if err != nil || line != `{"id":1,"method":"mining.subscribe","params":[]}`+"\n" {
+			t.Errorf("received %q, %v", line, err)
+		}
+	case <-ctx.Done():
+		t.Fatal("connection wasn't accepted")
+	}
+}
@@ -0,0 +1,312 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultMaxLineLength is the size of the bufio default buffer, which was the limit before
+	DefaultMaxLineLength = 4096
+
+	// Larger buffers are left to the garbage collector instead of being pooled
+	maxPooledBufferSize = 64 * 1024
+)
+
+var (
+	ErrLineTooLong = errors.New("line is too long")
+)
+
+var (
+	readerPools sync.Map // int -> *sync.Pool
+
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			buffer := make([]byte, 0, 512)
+
+			return &buffer
+		},
+	}
+)
+
+func getReader(reader io.Reader, size int) *bufio.Reader {
+	pool, _ := readerPools.LoadOrStore(size, &sync.Pool{})
+
+	if bufReader, ok := pool.(*sync.Pool).Get().(*bufio.Reader); ok {
+		bufReader.Reset(reader)
+
+		return bufReader
+	}
+
+	return bufio.NewReaderSize(reader, size)
+}
+
+func putReader(bufReader *bufio.Reader, size int) {
+	if size > maxPooledBufferSize {
+		return
+	}
+
+	// Drop the reference to the connection
+	bufReader.Reset(nil)
+
+	pool, _ := readerPools.LoadOrStore(size, &sync.Pool{})
+	pool.(*sync.Pool).Put(bufReader)
+}
+
+// appendLine writes the data and the delimiter with a single write, using a pooled buffer
+func appendLine(writer io.Writer, data []byte) (int, error) {
+	buffer := bufferPool.Get().(*[]byte)
+
+	line := append(append((*buffer)[:0], data...), '\n')
+
+	n, err := writer.Write(line)
+
+	if cap(line) <= maxPooledBufferSize {
+		*buffer = line
+		bufferPool.Put(buffer)
+	}
+
+	// The delimiter is not part of the caller's data
+	if n > len(data) {
+		n = len(data)
+	}
+
+	return n, err
+}
+
+// Decoder splits a stream into JSON-RPC lines without copying them
+type Decoder struct {
+	reader    *bufio.Reader
+	maxLength int
+	err       error
+}
+
+// ReadLine returns the next non-empty line without the delimiter,
+// the slice is only valid until the next call
+func (d *Decoder) ReadLine() ([]byte, error) {
+	for {
+		if d.err != nil {
+			return nil, d.err
+		}
+
+		line, err := d.reader.ReadSlice('\n')
+
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			d.err = ErrLineTooLong
+
+			return nil, d.err
+		case err != nil:
+			// The last line may not be terminated, return it before the error
+			d.err = err
+
+			if len(line) == 0 {
+				return nil, d.err
+			}
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			return line, nil
+		}
+	}
+}
+
+// Release returns the buffer to the pool, the decoder must not be used afterwards
+func (d *Decoder) Release() {
+	if d.reader == nil {
+		return
+	}
+
+	putReader(d.reader, d.maxLength)
+
+	d.reader = nil
+	d.err = io.ErrClosedPipe
+}
+
+// NewDecoder returns a decoder rejecting lines longer than maxLength, zero means DefaultMaxLineLength
+func NewDecoder(reader io.Reader, maxLength int) *Decoder {
+	if maxLength <= 0 {
+		maxLength = DefaultMaxLineLength
+	}
+
+	return &Decoder{
+		reader:    getReader(reader, maxLength),
+		maxLength: maxLength,
+	}
+}
+
+// Header is the routing part of a message
+type Header struct {
+	// ID aliases the parsed data, copy it if it's retained
+	ID     ID
+	Method string
+}
+
+// ParseHeader reads the top level id and method only, skipping params and result without decoding them
+func ParseHeader(data []byte) (Header, error) {
+	header := Header{}
+
+	i := skipSpace(data, 0)
+	if i >= len(data) || data[i] != '{' {
+		return header, ErrInvalidMessage
+	}
+
+	i = skipSpace(data, i+1)
+	if i < len(data) && data[i] == '}' {
+		return header, nil
+	}
+
+	for i < len(data) {
+		if data[i] != '"' {
+			return header, ErrInvalidMessage
+		}
+
+		keyEnd, err := skipString(data, i)
+		if err != nil {
+			return header, err
+		}
+
+		key := data[i+1 : keyEnd-1]
+
+		i = skipSpace(data, keyEnd)
+		if i >= len(data) || data[i] != ':' {
+			return header, ErrInvalidMessage
+		}
+
+		valueStart := skipSpace(data, i+1)
+
+		valueEnd, err := skipValue(data, valueStart)
+		if err != nil {
+			return header, err
+		}
+
+		switch string(key) {
+		case fieldID:
+			header.ID = data[valueStart:valueEnd]
+		case fieldMethod:
+			if data[valueStart] == '"' {
+				method := data[valueStart+1 : valueEnd-1]
+
+				// Escapes are rare in method names, only unquote them when present
+				if bytes.IndexByte(method, '\\') < 0 {
+					header.Method = string(method)
+				} else {
+					// A local keeps the header on the stack
+					var unquoted string
+					if err := json.Unmarshal(data[valueStart:valueEnd], &unquoted); err != nil {
+						return header, err
+					}
+
+					header.Method = unquoted
+				}
+			}
+		}
+
+		i = skipSpace(data, valueEnd)
+		if i >= len(data) {
+			return header, ErrInvalidMessage
+		}
+
+		switch data[i] {
+		case ',':
+			i = skipSpace(data, i+1)
+		case '}':
+			return header, nil
+		default:
+			return header, ErrInvalidMessage
+		}
+	}
+
+	return header, ErrInvalidMessage
+}
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			return i
+		}
+	}
+
+	return i
+}
+
+// skipString returns the index after the closing quote of the string starting at i
+func skipString(data []byte, i int) (int, error) {
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+
+	return 0, ErrInvalidMessage
+}
+
+// skipValue returns the index after the value starting at i
+func skipValue(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return 0, ErrInvalidMessage
+	}
+
+	switch data[i] {
+	case '"':
+		return skipString(data, i)
+	case '{', '[':
+		depth := 0
+
+		for i < len(data) {
+			switch data[i] {
+			case '"':
+				end, err := skipString(data, i)
+				if err != nil {
+					return 0, err
+				}
+
+				i = end
+
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+
+			i++
+		}
+
+		return 0, ErrInvalidMessage
+	default:
+		// Numbers, true, false and null
+		start := i
+
+		for i < len(data) {
+			switch data[i] {
+			case ',', '}', ']', ' ', '\t', '\r', '\n':
+				if i == start {
+					return 0, ErrInvalidMessage
+				}
+
+				return i, nil
+			}
+
+			i++
+		}
+
+		return 0, ErrInvalidMessage
+	}
+}
@@ -0,0 +1,176 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+var notify = []byte(`{"id":null,"method":"mining.notify","params":["bf0488","4c4f7bbaa0a7b4bd0d2a7e1e0a4cd9c8f3b5a2c1d7e6f5a4b3c2d1e0f9a8b7c6","3d8a9f8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f",true]}`)
+
+func TestDecoder(t *testing.T) {
+	stream := "\n{\"id\":1}\r\n  \n{\"id\":2}\n{\"id\":3}"
+
+	decoder := NewDecoder(strings.NewReader(stream), 0)
+	defer decoder.Release()
+
+	for _, expected := range []string{`{"id":1}`, `{"id":2}`, `{"id":3}`} {
+		line, err := decoder.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(line) != expected {
+			t.Errorf("expected %s, got %s", expected, line)
+		}
+	}
+
+	if _, err := decoder.ReadLine(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestDecoderLineTooLong(t *testing.T) {
+	decoder := NewDecoder(strings.NewReader(strings.Repeat("x", 64)+"\n"), 16)
+	defer decoder.Release()
+
+	if _, err := decoder.ReadLine(); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("expected ErrLineTooLong, got %v", err)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		message string
+		id      string
+		method  string
+	}{
+		{string(notify), "null", "mining.notify"},
+		{`{"method":"mining.submit","params":["a",{"b":"}"}],"id":"7"}`, `"7"`, "mining.submit"},
+		{` { "id" : 12 , "result" : true , "error" : null } `, "12", ""},
+		{`{"id":1,"method":"mining.subscribe"}`, "1", "mining.subscribe"},
+		{`{}`, "null", ""},
+	}
+
+	for _, test := range tests {
+		header, err := ParseHeader([]byte(test.message))
+		if err != nil {
+			t.Errorf("%s: %s", test.message, err)
+
+			continue
+		}
+
+		if header.ID.String() != test.id || header.Method != test.method {
+			t.Errorf("%s: got id %s and method %s", test.message, header.ID, header.Method)
+		}
+	}
+
+	for _, message := range []string{``, `[]`, `{"id":1`, `{"id":}`, `{"id":"1}`, `{id:1}`} {
+		if _, err := ParseHeader([]byte(message)); err == nil {
+			t.Errorf("%s: expected an error", message)
+		}
+	}
+}
+
+func TestAppendLine(t *testing.T) {
+	buffer := bytes.Buffer{}
+
+	n, err := appendLine(&buffer, notify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != len(notify) {
+		t.Errorf("expected %d bytes, got %d", len(notify), n)
+	}
+
+	if !bytes.Equal(buffer.Bytes(), append(append([]byte(nil), notify...), '\n')) {
+		t.Errorf("unexpected line %s", buffer.Bytes())
+	}
+}
+
+// The hot path of every message must not allocate, ParseHeader only allocates the method name
+func TestAllocations(t *testing.T) {
+	if allocs := testing.AllocsPerRun(100, func() {
+		_, _ = appendLine(io.Discard, notify)
+	}); allocs > 0 {
+		t.Errorf("appendLine allocates %.0f times", allocs)
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() {
+		_, _ = ParseHeader(notify)
+	}); allocs > 1 {
+		t.Errorf("ParseHeader allocates %.0f times", allocs)
+	}
+}
+
+// repeatReader returns the same line forever
+type repeatReader struct {
+	line   []byte
+	offset int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		copied := copy(p[n:], r.line[r.offset:])
+		n += copied
+		r.offset = (r.offset + copied) % len(r.line)
+	}
+
+	return n, nil
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	decoder := NewDecoder(&repeatReader{line: append(append([]byte(nil), notify...), '\n')}, 0)
+	defer decoder.Release()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(notify) + 1))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := decoder.ReadLine(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseHeader(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseHeader(notify); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUnmarshalHeader is what ParseHeader replaces
+func BenchmarkUnmarshalHeader(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		header := struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}{}
+
+		if err := json.Unmarshal(notify, &header); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendLine(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := appendLine(io.Discard, notify); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,11 +1,492 @@
 package jsonrpc
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
 
+// https://www.jsonrpc.org/specification
+
+const (
+	Version = "2.0"
+
+	fieldJSONRPC = "jsonrpc"
+	fieldID      = "id"
+	fieldMethod  = "method"
+	fieldParams  = "params"
+	fieldResult  = "result"
+	fieldError   = "error"
+	fieldWorker  = "worker"
+)
+
+var (
+	ErrInvalidMessage = errors.New("invalid message")
+	ErrInvalidError   = errors.New("invalid error")
+)
+
+var null = json.RawMessage("null")
+
+// ID keeps the raw JSON of an id, so numbers, strings and null are forwarded untouched
+type ID json.RawMessage
+
+func NewID(id int) ID {
+	return ID(strconv.Itoa(id))
+}
+
+func (i ID) IsNull() bool {
+	return len(i) == 0 || bytes.Equal(i, null)
+}
+
+func (i ID) String() string {
+	if i.IsNull() {
+		return "null"
+	}
+
+	return string(i)
+}
+
+func (i ID) MarshalJSON() ([]byte, error) {
+	if len(i) == 0 {
+		return null, nil
+	}
+
+	return i, nil
+}
+
+func (i *ID) UnmarshalJSON(data []byte) error {
+	*i = append((*i)[0:0], data...)
+
+	return nil
+}
+
+type ErrorStyle int
+
+const (
+	// ErrorStyleObject is the JSON-RPC 2.0 {"code": 0, "message": "", "data": null}
+	ErrorStyleObject ErrorStyle = iota
+	// ErrorStyleArray is the stratum [code, "message", data]
+	ErrorStyleArray
+)
+
+type Error struct {
+	Code    int
+	Message string
+	Data    json.RawMessage
+	Style   ErrorStyle
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+func (e Error) MarshalJSON() ([]byte, error) {
+	data := e.Data
+	if len(data) == 0 {
+		data = null
+	}
+
+	if e.Style == ErrorStyleArray {
+		return json.Marshal([]interface{}{e.Code, e.Message, data})
+	}
+
+	object := struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data,omitempty"`
+	}{
+		Code:    e.Code,
+		Message: e.Message,
+		Data:    e.Data,
+	}
+
+	return json.Marshal(object)
+}
+
+func (e *Error) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+
+	if len(data) == 0 {
+		return ErrInvalidError
+	}
+
+	switch data[0] {
+	case '[':
+		array := make([]json.RawMessage, 0, 3)
+		if err := json.Unmarshal(data, &array); err != nil {
+			return err
+		}
+
+		if len(array) < 2 {
+			return ErrInvalidError
+		}
+
+		*e = Error{
+			Style: ErrorStyleArray,
+		}
+
+		if err := json.Unmarshal(array[0], &e.Code); err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(array[1], &e.Message); err != nil {
+			return err
+		}
+
+		if len(array) > 2 && !bytes.Equal(array[2], null) {
+			e.Data = array[2]
+		}
+	case '{':
+		object := struct {
+			Code    int             `json:"code"`
+			Message string          `json:"message"`
+			Data    json.RawMessage `json:"data"`
+		}{}
+
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+
+		*e = Error{
+			Code:    object.Code,
+			Message: object.Message,
+			Data:    object.Data,
+			Style:   ErrorStyleObject,
+		}
+	default:
+		return ErrInvalidError
+	}
+
+	return nil
+}
+
+// Request is a call expecting a response, Worker is the EthProxy extension
+// naming the worker of eth_submitWork and eth_submitHashrate
 type Request struct {
-	ID     int             `json:"id"`
-	Method string          `json:"method,omitempty"`
-	Params json.RawMessage `json:"params,omitempty"`
-	Result json.RawMessage `json:"result,omitempty"`
-	Worker string          `json:"worker,omitempty"`
+	JSONRPC string
+	ID      ID
+	Method  string
+	Params  json.RawMessage
+	Worker  string
+	// Extra keeps the unknown and null fields, so they survive forwarding
+	Extra map[string]json.RawMessage
+}
+
+func (r Request) MarshalJSON() ([]byte, error) {
+	fields := newObjectWriter(r.Extra)
+
+	if r.JSONRPC != "" {
+		fields.add(fieldJSONRPC, r.JSONRPC)
+	}
+
+	fields.add(fieldID, r.ID)
+
+	if r.Method != "" {
+		fields.add(fieldMethod, r.Method)
+	}
+
+	if len(r.Params) > 0 {
+		fields.add(fieldParams, r.Params)
+	}
+
+	if r.Worker != "" {
+		fields.add(fieldWorker, r.Worker)
+	}
+
+	return fields.bytes()
+}
+
+func (r *Request) UnmarshalJSON(data []byte) error {
+	fields, err := newObjectReader(data)
+	if err != nil {
+		return err
+	}
+
+	*r = Request{}
+
+	if err := fields.take(fieldJSONRPC, &r.JSONRPC); err != nil {
+		return err
+	}
+
+	// A null id is a meaningful part of the message, keep it as is
+	if raw, ok := fields[fieldID]; ok {
+		r.ID = ID(raw)
+
+		delete(fields, fieldID)
+	}
+
+	if err := fields.take(fieldMethod, &r.Method); err != nil {
+		return err
+	}
+
+	if err := fields.take(fieldParams, &r.Params); err != nil {
+		return err
+	}
+
+	if err := fields.take(fieldWorker, &r.Worker); err != nil {
+		return err
+	}
+
+	r.Extra = fields.extra()
+
+	return nil
+}
+
+type Response struct {
+	JSONRPC string
+	ID      ID
+	Result  json.RawMessage
+	Error   *Error
+	// Extra keeps the unknown and null fields, so they survive forwarding
+	Extra map[string]json.RawMessage
+}
+
+func (r Response) MarshalJSON() ([]byte, error) {
+	fields := newObjectWriter(r.Extra)
+
+	if r.JSONRPC != "" {
+		fields.add(fieldJSONRPC, r.JSONRPC)
+	}
+
+	fields.add(fieldID, r.ID)
+
+	if len(r.Result) > 0 {
+		fields.add(fieldResult, r.Result)
+	}
+
+	if r.Error != nil {
+		fields.add(fieldError, r.Error)
+	}
+
+	return fields.bytes()
+}
+
+func (r *Response) UnmarshalJSON(data []byte) error {
+	fields, err := newObjectReader(data)
+	if err != nil {
+		return err
+	}
+
+	*r = Response{}
+
+	if err := fields.take(fieldJSONRPC, &r.JSONRPC); err != nil {
+		return err
+	}
+
+	if raw, ok := fields[fieldID]; ok {
+		r.ID = ID(raw)
+
+		delete(fields, fieldID)
+	}
+
+	if err := fields.take(fieldResult, &r.Result); err != nil {
+		return err
+	}
+
+	if err := fields.take(fieldError, &r.Error); err != nil {
+		return err
+	}
+
+	r.Extra = fields.extra()
+
+	return nil
+}
+
+// Notification is a call without response, like mining.notify,
+// a null or zero id sent by pools is kept in Extra
+type Notification struct {
+	JSONRPC string
+	Method  string
+	Params  json.RawMessage
+	// Extra keeps the unknown and null fields, so they survive forwarding
+	Extra map[string]json.RawMessage
+}
+
+func (n Notification) MarshalJSON() ([]byte, error) {
+	fields := newObjectWriter(n.Extra)
+
+	if n.JSONRPC != "" {
+		fields.add(fieldJSONRPC, n.JSONRPC)
+	}
+
+	if n.Method != "" {
+		fields.add(fieldMethod, n.Method)
+	}
+
+	if len(n.Params) > 0 {
+		fields.add(fieldParams, n.Params)
+	}
+
+	return fields.bytes()
+}
+
+func (n *Notification) UnmarshalJSON(data []byte) error {
+	fields, err := newObjectReader(data)
+	if err != nil {
+		return err
+	}
+
+	*n = Notification{}
+
+	if err := fields.take(fieldJSONRPC, &n.JSONRPC); err != nil {
+		return err
+	}
+
+	if err := fields.take(fieldMethod, &n.Method); err != nil {
+		return err
+	}
+
+	if err := fields.take(fieldParams, &n.Params); err != nil {
+		return err
+	}
+
+	n.Extra = fields.extra()
+
+	return nil
+}
+
+// Unmarshal decodes the data into a *Request, *Response or *Notification,
+// a message with a method and without an id or with a null id is a notification
+func Unmarshal(data []byte) (interface{}, error) {
+	fields, err := newObjectReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var message json.Unmarshaler
+
+	_, hasMethod := fields[fieldMethod]
+	id, hasID := fields[fieldID]
+
+	switch {
+	case hasMethod && hasID && !ID(id).IsNull():
+		message = &Request{}
+	case hasMethod:
+		message = &Notification{}
+	case hasID:
+		message = &Response{}
+	default:
+		return nil, ErrInvalidMessage
+	}
+
+	if err := message.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+type objectReader map[string]json.RawMessage
+
+// take moves a known field into the value, null fields are left for extra
+func (o objectReader) take(name string, value interface{}) error {
+	raw, ok := o[name]
+	if !ok || bytes.Equal(raw, null) {
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, value); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	delete(o, name)
+
+	return nil
+}
+
+func (o objectReader) extra() map[string]json.RawMessage {
+	if len(o) == 0 {
+		return nil
+	}
+
+	return o
+}
+
+func newObjectReader(data []byte) (objectReader, error) {
+	fields := make(objectReader)
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	if fields == nil {
+		return nil, ErrInvalidMessage
+	}
+
+	return fields, nil
+}
+
+type objectWriter struct {
+	buffer  bytes.Buffer
+	extra   map[string]json.RawMessage
+	written map[string]struct{}
+	err     error
+}
+
+func (o *objectWriter) add(name string, value interface{}) {
+	if o.err != nil {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		o.err = fmt.Errorf("%s: %w", name, err)
+
+		return
+	}
+
+	o.write(name, data)
+}
+
+func (o *objectWriter) write(name string, data []byte) {
+	if o.buffer.Len() > 1 {
+		o.buffer.WriteByte(',')
+	}
+
+	key, _ := json.Marshal(name)
+
+	o.buffer.Write(key)
+	o.buffer.WriteByte(':')
+	o.buffer.Write(data)
+
+	o.written[name] = struct{}{}
+}
+
+// bytes appends the extra fields in a stable order, known fields which are set take precedence
+func (o *objectWriter) bytes() ([]byte, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+
+	names := make([]string, 0, len(o.extra))
+
+	for name := range o.extra {
+		if _, ok := o.written[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		o.write(name, o.extra[name])
+	}
+
+	o.buffer.WriteByte('}')
+
+	return o.buffer.Bytes(), nil
+}
+
+func newObjectWriter(extra map[string]json.RawMessage) *objectWriter {
+	writer := objectWriter{
+		extra:   extra,
+		written: make(map[string]struct{}, 8),
+	}
+
+	writer.buffer.WriteByte('{')
+
+	return &writer
 }
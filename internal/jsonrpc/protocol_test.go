@@ -0,0 +1,142 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// equalJSON compares two documents regardless of the field order
+func equalJSON(t *testing.T, expected, actual []byte) {
+	t.Helper()
+
+	var e, a interface{}
+
+	if err := json.Unmarshal(expected, &e); err != nil {
+		t.Fatalf("expected %s: %s", expected, err)
+	}
+
+	if err := json.Unmarshal(actual, &a); err != nil {
+		t.Fatalf("actual %s: %s", actual, err)
+	}
+
+	if !reflect.DeepEqual(e, a) {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		kind    interface{}
+	}{
+		{"request with number id", `{"id":1,"method":"mining.subscribe","params":["miner/1.0"]}`, &Request{}},
+		{"request with string id", `{"jsonrpc":"2.0","id":"abc","method":"eth_submitLogin","params":["0xabc"],"worker":"rig01"}`, &Request{}},
+		{"request with unknown fields", `{"id":2,"method":"login","params":{"login":"x"},"agent":"xmrig","rigid":null}`, &Request{}},
+		{"notification with null id", `{"id":null,"method":"mining.notify","params":["1","s","h",true]}`, &Notification{}},
+		{"notification without id", `{"jsonrpc":"2.0","method":"job","params":{"job_id":"1"}}`, &Notification{}},
+		{"response with array error", `{"id":4,"result":null,"error":[21,"Job not found",null]}`, &Response{}},
+		{"response with object error", `{"jsonrpc":"2.0","id":"x","error":{"code":-32601,"message":"Method not found","data":{"method":"x"}}}`, &Response{}},
+		{"response with null id", `{"id":null,"result":true,"error":null}`, &Response{}},
+		{"response with unknown fields", `{"id":5,"result":true,"error":null,"status":"OK"}`, &Response{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message, err := Unmarshal([]byte(test.message))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reflect.TypeOf(message) != reflect.TypeOf(test.kind) {
+				t.Fatalf("expected %T, got %T", test.kind, message)
+			}
+
+			data, err := json.Marshal(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			equalJSON(t, []byte(test.message), data)
+		})
+	}
+}
+
+func TestID(t *testing.T) {
+	request := Request{}
+	if err := json.Unmarshal([]byte(`{"id":"0001","method":"x"}`), &request); err != nil {
+		t.Fatal(err)
+	}
+
+	// The raw id is forwarded byte for byte
+	if request.ID.String() != `"0001"` {
+		t.Errorf("expected \"0001\", got %s", request.ID)
+	}
+
+	if request.ID.IsNull() {
+		t.Error("string id is null")
+	}
+
+	if !(ID(nil)).IsNull() || !ID("null").IsNull() {
+		t.Error("empty or null id isn't null")
+	}
+
+	data, err := json.Marshal(Request{Method: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equalJSON(t, []byte(`{"id":null,"method":"x"}`), data)
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected Error
+	}{
+		{`[21,"Job not found",null]`, Error{Code: 21, Message: "Job not found", Style: ErrorStyleArray}},
+		{`[23,"Low difficulty share"]`, Error{Code: 23, Message: "Low difficulty share", Style: ErrorStyleArray}},
+		{`{"code":-1,"message":"Invalid job id"}`, Error{Code: -1, Message: "Invalid job id", Style: ErrorStyleObject}},
+	}
+
+	for _, test := range tests {
+		e := Error{}
+		if err := json.Unmarshal([]byte(test.message), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(e, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.message, test.expected, e)
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The style survives, a missing data is written as null in the array style
+		again := Error{}
+		if err := json.Unmarshal(data, &again); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(again, test.expected) {
+			t.Errorf("%s: round trip gave %s", test.message, data)
+		}
+	}
+
+	for _, message := range []string{`"error"`, `[1]`, `12`} {
+		if err := json.Unmarshal([]byte(message), &Error{}); err == nil {
+			t.Errorf("%s: expected an error", message)
+		}
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, message := range []string{`{}`, `null`, `[]`, `{"params":[]}`} {
+		if _, err := Unmarshal([]byte(message)); err == nil {
+			t.Errorf("%s: expected an error", message)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+type contextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// newProxyDialer returns a dialer tunneling through the proxy,
+// socks5 and socks5h use SOCKS5 and http uses the CONNECT method
+func newProxyDialer(rawURL string, forward *tcpDialer) (contextDialer, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(proxyURL, forward)
+		if err != nil {
+			return nil, err
+		}
+
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("proxy %s doesn't support context", proxyURL.Redacted())
+		}
+
+		return contextDialer, nil
+	case "http":
+		return &httpProxyDialer{
+			proxyURL: proxyURL,
+			forward:  forward,
+		}, nil
+	default:
+		return nil, fmt.Errorf("proxy scheme %s isn't supported", proxyURL.Scheme)
+	}
+}
+
+var _ contextDialer = &httpProxyDialer{}
+
+type httpProxyDialer struct {
+	proxyURL *url.URL
+	forward  contextDialer
+}
+
+func (d *httpProxyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	netConn, err := d.forward.DialContext(ctx, network, d.proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// The CONNECT exchange is bounded by the same deadline as the dial
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = netConn.SetDeadline(deadline)
+	}
+
+	request := http.Request{
+		Method: http.MethodConnect,
+		URL: &url.URL{
+			Opaque: address,
+		},
+		Host:   address,
+		Header: make(http.Header),
+	}
+
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+
+		request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+
+	if err := request.Write(netConn); err != nil {
+		_ = netConn.Close()
+
+		return nil, err
+	}
+
+	reader := bufio.NewReader(netConn)
+
+	response, err := http.ReadResponse(reader, &request)
+	if err != nil {
+		_ = netConn.Close()
+
+		return nil, err
+	}
+
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		_ = netConn.Close()
+
+		return nil, fmt.Errorf("proxy %s refused to connect %s: %s", d.proxyURL.Redacted(), address, response.Status)
+	}
+
+	_ = netConn.SetDeadline(time.Time{})
+
+	// Pools may speak first, keep what has been read ahead of the response
+	if reader.Buffered() > 0 {
+		return &bufferedConn{
+			Conn:   netConn,
+			reader: reader,
+		}, nil
+	}
+
+	return netConn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,315 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultResolveInterval = time.Minute * 5
+
+	// SchemePrefixSRV marks a pool URL whose host is a SRV record, like srv+tls://_stratum._tcp.pool.example
+	SchemePrefixSRV = "srv+"
+)
+
+var (
+	ErrNoCandidate = errors.New("no candidate address")
+)
+
+// LookupResolver is satisfied by *net.Resolver, a stub can be used to serve local records
+type LookupResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type Candidate struct {
+	// Address is the host:port dialed, Host is the name to verify the certificate against
+	Address   string
+	Host      string
+	Latency   time.Duration
+	Reachable bool
+}
+
+type resolution struct {
+	candidates []Candidate
+	expiresAt  time.Time
+}
+
+// Resolver turns a pool host into candidate addresses, measures the connect latency to each of them
+// and prefers the fastest reachable one, candidates are resolved and measured again after Interval
+type Resolver struct {
+	Resolver LookupResolver
+	Interval time.Duration
+
+	resolutions map[string]*resolution
+	locker      sync.Mutex
+}
+
+// Candidates returns the last measured candidates of the host, fastest first
+func (r *Resolver) Candidates(host string) []Candidate {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if resolution, ok := r.resolutions[host]; ok {
+		return append([]Candidate(nil), resolution.candidates...)
+	}
+
+	return nil
+}
+
+func (r *Resolver) lookupResolver() LookupResolver {
+	if r.Resolver == nil {
+		return net.DefaultResolver
+	}
+
+	return r.Resolver
+}
+
+func (r *Resolver) interval() time.Duration {
+	if r.Interval == 0 {
+		return DefaultResolveInterval
+	}
+
+	return r.Interval
+}
+
+func (r *Resolver) resolve(ctx context.Context, host string, srv bool) ([]Candidate, error) {
+	candidates := make([]Candidate, 0)
+
+	if srv {
+		_, records, err := r.lookupResolver().LookupSRV(ctx, "", "", host)
+		if err != nil {
+			return nil, err
+		}
+
+		// Records are already sorted by priority and randomized by weight
+		for _, record := range records {
+			target := strings.TrimSuffix(record.Target, ".")
+
+			candidates = append(candidates, Candidate{
+				Address: net.JoinHostPort(target, strconv.Itoa(int(record.Port))),
+				Host:    target,
+			})
+		}
+	} else {
+		hostname, port, err := net.SplitHostPort(host)
+		if err != nil {
+			return nil, err
+		}
+
+		addresses, err := r.lookupResolver().LookupIPAddr(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, address := range addresses {
+			candidates = append(candidates, Candidate{
+				Address: net.JoinHostPort(address.String(), port),
+				Host:    hostname,
+			})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, ErrNoCandidate
+	}
+
+	return candidates, nil
+}
+
+// dial connects to the best candidate of the host, when the candidates are stale they are resolved again
+// and all of them are raced, the first connection wins and the others are only kept for their latency
+func (r *Resolver) dial(ctx context.Context, forward contextDialer, network, host string, srv bool) (net.Conn, Candidate, error) {
+	r.locker.Lock()
+
+	if r.resolutions == nil {
+		r.resolutions = make(map[string]*resolution)
+	}
+
+	cached, ok := r.resolutions[host]
+
+	r.locker.Unlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		var lastErr error = ErrNoCandidate
+
+		for i, candidate := range cached.candidates {
+			// Every candidate gets its share of the time left, an unresponsive one can't use up the others' time
+			timeout := DefaultDialTimeout
+			if deadline, ok := ctx.Deadline(); ok {
+				timeout = time.Until(deadline)
+			}
+
+			dialCtx, cancel := context.WithTimeout(ctx, timeout/time.Duration(len(cached.candidates)-i))
+
+			netConn, err := forward.DialContext(dialCtx, network, candidate.Address)
+
+			cancel()
+
+			if err == nil {
+				if i > 0 {
+					r.expire(host, cached)
+				}
+
+				return netConn, candidate, nil
+			}
+
+			lastErr = err
+		}
+
+		r.expire(host, cached)
+
+		return nil, Candidate{}, lastErr
+	}
+
+	candidates, err := r.resolve(ctx, host, srv)
+	if err != nil {
+		return nil, Candidate{}, err
+	}
+
+	type result struct {
+		index     int
+		candidate Candidate
+		netConn   net.Conn
+		err       error
+	}
+
+	// Probes outlive the caller's context, slower candidates are still measured after the winner returns
+	timeout := DefaultDialTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	probeCtx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	results := make(chan result, len(candidates))
+
+	for i := range candidates {
+		go func(i int) {
+			startedAt := time.Now()
+
+			netConn, err := forward.DialContext(probeCtx, network, candidates[i].Address)
+			if err == nil {
+				candidates[i].Latency = time.Since(startedAt)
+				candidates[i].Reachable = true
+			}
+
+			results <- result{
+				index:     i,
+				candidate: candidates[i],
+				netConn:   netConn,
+				err:       err,
+			}
+		}(i)
+	}
+
+	winners := make(chan result, 1)
+
+	go func() {
+		defer cancel()
+
+		winner := result{
+			index: -1,
+			err:   ErrNoCandidate,
+		}
+
+		for range candidates {
+			result := <-results
+
+			switch {
+			case result.err != nil:
+				if winner.index < 0 {
+					winner.err = result.err
+				}
+			case winner.index < 0:
+				winner = result
+
+				winners <- winner
+			default:
+				_ = result.netConn.Close()
+			}
+		}
+
+		if winner.index < 0 {
+			winners <- winner
+		}
+
+		// Reachable candidates first, fastest first
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].Reachable != candidates[j].Reachable {
+				return candidates[i].Reachable
+			}
+
+			return candidates[i].Latency < candidates[j].Latency
+		})
+
+		r.locker.Lock()
+
+		r.resolutions[host] = &resolution{
+			candidates: candidates,
+			expiresAt:  time.Now().Add(r.interval()),
+		}
+
+		r.locker.Unlock()
+	}()
+
+	select {
+	case winner := <-winners:
+		if winner.index < 0 {
+			return nil, Candidate{}, winner.err
+		}
+
+		return winner.netConn, winner.candidate, nil
+	case <-ctx.Done():
+		// Nobody will use the connection if it's established later
+		go func() {
+			if winner := <-winners; winner.netConn != nil {
+				_ = winner.netConn.Close()
+			}
+		}()
+
+		return nil, Candidate{}, ctx.Err()
+	}
+}
+
+// expire makes the next dial resolve and race the candidates again, the best of them failed so their order is stale
+func (r *Resolver) expire(host string, cached *resolution) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if r.resolutions[host] == cached {
+		r.resolutions[host] = &resolution{
+			candidates: cached.candidates,
+		}
+	}
+}
+
+var _ contextDialer = &candidateDialer{}
+
+// candidateDialer ignores the address it's asked for and dials the best candidate of the host instead
+type candidateDialer struct {
+	resolver *Resolver
+	forward  contextDialer
+	host     string
+	srv      bool
+
+	// candidate is the last dialed one
+	candidate Candidate
+}
+
+func (d *candidateDialer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	netConn, candidate, err := d.resolver.dial(ctx, d.forward, network, d.host, d.srv)
+	if err != nil {
+		return nil, err
+	}
+
+	d.candidate = candidate
+
+	return netConn, nil
+}
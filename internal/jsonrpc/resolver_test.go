@@ -0,0 +1,321 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ LookupResolver = &stubResolver{}
+
+// stubResolver serves local records and counts the lookups
+type stubResolver struct {
+	srv     map[string][]*net.SRV
+	ip      map[string][]net.IPAddr
+	lookups int
+	locker  sync.Mutex
+}
+
+func (r *stubResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	r.lookups++
+
+	return name, r.srv[name], nil
+}
+
+func (r *stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	r.lookups++
+
+	addresses, ok := r.ip[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	return addresses, nil
+}
+
+var _ contextDialer = &stubDialer{}
+
+// stubDialer connects to every address after its delay, addresses without a delay are unreachable
+type stubDialer struct {
+	delays map[string]time.Duration
+}
+
+func (d *stubDialer) DialContext(ctx context.Context, _, address string) (net.Conn, error) {
+	delay, ok := d.delays[address]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	conn, peer := net.Pipe()
+	_ = peer.Close()
+
+	return conn, nil
+}
+
+func ip(address string) net.IPAddr {
+	return net.IPAddr{IP: net.ParseIP(address)}
+}
+
+// waitCandidates waits for the race to finish measuring the candidates of the host
+func waitCandidates(t *testing.T, resolver *Resolver, host string) []Candidate {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		if candidates := resolver.Candidates(host); candidates != nil {
+			return candidates
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Fatalf("%s was never measured", host)
+
+	return nil
+}
+
+func TestResolveSRV(t *testing.T) {
+	resolver := Resolver{
+		Resolver: &stubResolver{
+			srv: map[string][]*net.SRV{
+				"_stratum._tcp.pool.example": {
+					{Target: "eu1.pool.example.", Port: 5555, Priority: 1},
+					{Target: "us1.pool.example.", Port: 4444, Priority: 2},
+				},
+			},
+		},
+	}
+
+	candidates, err := resolver.resolve(context.Background(), "_stratum._tcp.pool.example", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Candidate{
+		{Address: "eu1.pool.example:5555", Host: "eu1.pool.example"},
+		{Address: "us1.pool.example:4444", Host: "us1.pool.example"},
+	}
+
+	if len(candidates) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, candidates)
+	}
+
+	for i := range expected {
+		if candidates[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], candidates[i])
+		}
+	}
+
+	if _, err := resolver.resolve(context.Background(), "_stratum._tcp.empty.example", true); !errors.Is(err, ErrNoCandidate) {
+		t.Errorf("expected ErrNoCandidate, got %v", err)
+	}
+}
+
+func TestResolveIP(t *testing.T) {
+	resolver := Resolver{
+		Resolver: &stubResolver{
+			ip: map[string][]net.IPAddr{
+				"pool.example": {ip("192.0.2.1"), ip("2001:db8::1")},
+			},
+		},
+	}
+
+	candidates, err := resolver.resolve(context.Background(), "pool.example:5555", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(candidates) != 2 || candidates[0].Address != "192.0.2.1:5555" || candidates[1].Address != "[2001:db8::1]:5555" {
+		t.Errorf("unexpected candidates %v", candidates)
+	}
+
+	for _, candidate := range candidates {
+		if candidate.Host != "pool.example" {
+			t.Errorf("expected host pool.example, got %s", candidate.Host)
+		}
+	}
+
+	if _, err := resolver.resolve(context.Background(), "missing.example:5555", false); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestDialFastest(t *testing.T) {
+	lookup := &stubResolver{
+		ip: map[string][]net.IPAddr{
+			"pool.example": {ip("192.0.2.1"), ip("192.0.2.2"), ip("192.0.2.3")},
+		},
+	}
+
+	resolver := Resolver{
+		Resolver: lookup,
+		Interval: time.Minute,
+	}
+
+	forward := &stubDialer{
+		delays: map[string]time.Duration{
+			"192.0.2.1:5555": time.Millisecond * 100,
+			"192.0.2.2:5555": time.Millisecond * 10,
+		},
+	}
+
+	conn, candidate, err := resolver.dial(context.Background(), forward, "tcp", "pool.example:5555", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.Close()
+
+	if candidate.Address != "192.0.2.2:5555" {
+		t.Errorf("expected the fastest candidate, got %s", candidate.Address)
+	}
+
+	// Reachable candidates come first, fastest first
+	candidates := waitCandidates(t, &resolver, "pool.example:5555")
+
+	order := []string{"192.0.2.2:5555", "192.0.2.1:5555", "192.0.2.3:5555"}
+	for i, address := range order {
+		if candidates[i].Address != address {
+			t.Fatalf("expected %v, got %v", order, candidates)
+		}
+	}
+
+	if !candidates[0].Reachable || !candidates[1].Reachable || candidates[2].Reachable {
+		t.Errorf("unexpected reachability %v", candidates)
+	}
+
+	if candidates[0].Latency >= candidates[1].Latency {
+		t.Errorf("unexpected latencies %v", candidates)
+	}
+
+	// Fresh candidates are dialed in order without resolving again
+	conn, candidate, err = resolver.dial(context.Background(), forward, "tcp", "pool.example:5555", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.Close()
+
+	if candidate.Address != "192.0.2.2:5555" {
+		t.Errorf("expected the cached fastest candidate, got %s", candidate.Address)
+	}
+
+	if lookup.lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", lookup.lookups)
+	}
+}
+
+func TestDialUnreachable(t *testing.T) {
+	resolver := Resolver{
+		Resolver: &stubResolver{
+			ip: map[string][]net.IPAddr{
+				"pool.example": {ip("192.0.2.1"), ip("192.0.2.2")},
+			},
+		},
+	}
+
+	if _, _, err := resolver.dial(context.Background(), &stubDialer{}, "tcp", "pool.example:5555", false); err == nil {
+		t.Error("expected an error")
+	}
+
+	for _, candidate := range waitCandidates(t, &resolver, "pool.example:5555") {
+		if candidate.Reachable {
+			t.Errorf("%s is reachable", candidate.Address)
+		}
+	}
+}
+
+func TestDialCanceled(t *testing.T) {
+	resolver := Resolver{
+		Resolver: &stubResolver{
+			ip: map[string][]net.IPAddr{
+				"pool.example": {ip("192.0.2.1")},
+			},
+		},
+	}
+
+	forward := &stubDialer{
+		delays: map[string]time.Duration{
+			"192.0.2.1:5555": time.Millisecond * 200,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	if _, _, err := resolver.dial(ctx, forward, "tcp", "pool.example:5555", false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestDialStaleCandidate(t *testing.T) {
+	lookup := &stubResolver{
+		ip: map[string][]net.IPAddr{
+			"pool.example": {ip("192.0.2.1"), ip("192.0.2.2")},
+		},
+	}
+
+	resolver := Resolver{
+		Resolver: lookup,
+		Interval: time.Minute,
+	}
+
+	forward := &stubDialer{
+		delays: map[string]time.Duration{
+			"192.0.2.1:5555": time.Millisecond * 30,
+			"192.0.2.2:5555": time.Millisecond * 10,
+		},
+	}
+
+	conn, _, err := resolver.dial(context.Background(), forward, "tcp", "pool.example:5555", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.Close()
+
+	waitCandidates(t, &resolver, "pool.example:5555")
+
+	// The cached best candidate starts dropping packets, the next one still gets its share of the deadline
+	forward.delays["192.0.2.2:5555"] = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*400)
+	defer cancel()
+
+	conn, candidate, err := resolver.dial(ctx, forward, "tcp", "pool.example:5555", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.Close()
+
+	if candidate.Address != "192.0.2.1:5555" {
+		t.Errorf("expected the next candidate, got %s", candidate.Address)
+	}
+
+	// The stale order isn't used again, the candidates are resolved and raced
+	conn, _, err = resolver.dial(context.Background(), forward, "tcp", "pool.example:5555", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.Close()
+
+	if lookup.lookups != 2 {
+		t.Errorf("expected 2 lookups, got %d", lookup.lookups)
+	}
+}
@@ -0,0 +1,108 @@
+package jsonrpc
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrFingerprintMismatch = errors.New("certificate fingerprint mismatch")
+)
+
+type TLSOption struct {
+	// CA is a PEM bundle used instead of the system roots
+	CA          string
+	ServerName  string
+	Certificate string
+	PrivateKey  string
+	// MinVersion is one of 1.0, 1.1, 1.2 and 1.3
+	MinVersion string
+	// Fingerprints are hex encoded SHA-256 digests of the accepted leaf certificates
+	Fingerprints []string
+	// Insecure skips the chain verification for self-signed pools, pinned fingerprints are still checked
+	Insecure bool
+}
+
+func NewTLSConfig(option TLSOption) (*tls.Config, error) {
+	tlsConfig := tls.Config{
+		ServerName:         option.ServerName,
+		InsecureSkipVerify: option.Insecure,
+	}
+
+	if option.CA != "" {
+		data, err := os.ReadFile(option.CA)
+		if err != nil {
+			return nil, err
+		}
+
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", option.CA)
+		}
+
+		tlsConfig.RootCAs = certPool
+	}
+
+	if option.Certificate != "" {
+		certificate, err := tls.LoadX509KeyPair(option.Certificate, option.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{
+			certificate,
+		}
+	}
+
+	switch option.MinVersion {
+	case "":
+	case "1.0":
+		tlsConfig.MinVersion = tls.VersionTLS10
+	case "1.1":
+		tlsConfig.MinVersion = tls.VersionTLS11
+	case "1.2":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("tls version %s isn't supported", option.MinVersion)
+	}
+
+	if len(option.Fingerprints) > 0 {
+		fingerprints := make(map[string]struct{}, len(option.Fingerprints))
+
+		for _, fingerprint := range option.Fingerprints {
+			// Accept the colon separated format printed by openssl
+			fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+
+			if _, err := hex.DecodeString(fingerprint); err != nil || len(fingerprint) != sha256.Size*2 {
+				return nil, fmt.Errorf("invalid certificate fingerprint %s", fingerprint)
+			}
+
+			fingerprints[fingerprint] = struct{}{}
+		}
+
+		// Runs after the chain verification, or alone if it's skipped
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return ErrFingerprintMismatch
+			}
+
+			digest := sha256.Sum256(state.PeerCertificates[0].Raw)
+
+			if _, ok := fingerprints[hex.EncodeToString(digest[:])]; !ok {
+				return ErrFingerprintMismatch
+			}
+
+			return nil
+		}
+	}
+
+	return &tlsConfig, nil
+}
@@ -0,0 +1,155 @@
+package jsonrpc
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTLSServer serves the certificate until the test ends, handshakes are completed and the connection dropped
+func newTLSServer(t *testing.T, certificate tls.Certificate) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func fingerprint(certificate tls.Certificate) string {
+	digest := sha256.Sum256(certificate.Certificate[0])
+
+	return hex.EncodeToString(digest[:])
+}
+
+// colons formats the fingerprint the way openssl prints it
+func colons(fingerprint string) string {
+	pairs := make([]string, 0, len(fingerprint)/2)
+
+	for i := 0; i < len(fingerprint); i += 2 {
+		pairs = append(pairs, strings.ToUpper(fingerprint[i:i+2]))
+	}
+
+	return strings.Join(pairs, ":")
+}
+
+func TestFingerprints(t *testing.T) {
+	certificate, _ := newTestCertificate(t, "localhost")
+	other, _ := newTestCertificate(t, "localhost")
+
+	address := newTLSServer(t, certificate)
+
+	// The chain is verified against the certificate itself
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		option TLSOption
+		err    error
+	}{
+		{"matching pin", TLSOption{CA: caPath, ServerName: "localhost", Fingerprints: []string{fingerprint(certificate)}}, nil},
+		{"one of the pins", TLSOption{CA: caPath, ServerName: "localhost", Fingerprints: []string{fingerprint(other), fingerprint(certificate)}}, nil},
+		{"colon separated pin", TLSOption{CA: caPath, ServerName: "localhost", Fingerprints: []string{colons(fingerprint(certificate))}}, nil},
+		{"mismatched pin", TLSOption{CA: caPath, ServerName: "localhost", Fingerprints: []string{fingerprint(other)}}, ErrFingerprintMismatch},
+		{"insecure with pin", TLSOption{Insecure: true, Fingerprints: []string{fingerprint(certificate)}}, nil},
+		{"insecure with mismatched pin", TLSOption{Insecure: true, Fingerprints: []string{fingerprint(other)}}, ErrFingerprintMismatch},
+	}
+
+	for _, test := range tests {
+		tlsConfig, err := NewTLSConfig(test.option)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		conn, err := tls.Dial("tcp", address, tlsConfig)
+		if err == nil {
+			_ = conn.Close()
+		}
+
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	// A pin doesn't replace the chain verification unless it's skipped
+	tlsConfig, err := NewTLSConfig(TLSOption{ServerName: "localhost", Fingerprints: []string{fingerprint(certificate)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conn, err := tls.Dial("tcp", address, tlsConfig); err == nil {
+		_ = conn.Close()
+
+		t.Error("an untrusted chain was accepted for its pin")
+	}
+}
+
+func TestInvalidFingerprints(t *testing.T) {
+	certificate, _ := newTestCertificate(t, "localhost")
+	valid := fingerprint(certificate)
+
+	for _, pin := range []string{
+		valid[:len(valid)-2],
+		valid + "00",
+		"zz" + valid[2:],
+		"",
+	} {
+		if _, err := NewTLSConfig(TLSOption{Fingerprints: []string{pin}}); err == nil {
+			t.Errorf("fingerprint %q was accepted", pin)
+		}
+	}
+}
+
+func TestMinVersion(t *testing.T) {
+	versions := map[string]uint16{
+		"":    0,
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	for version, expected := range versions {
+		tlsConfig, err := NewTLSConfig(TLSOption{MinVersion: version})
+		if err != nil {
+			t.Fatalf("%q: %s", version, err)
+		}
+
+		if tlsConfig.MinVersion != expected {
+			t.Errorf("%q: got %x, want %x", version, tlsConfig.MinVersion, expected)
+		}
+	}
+
+	for _, version := range []string{"1.4", "tls1.2", "1"} {
+		if _, err := NewTLSConfig(TLSOption{MinVersion: version}); err == nil {
+			t.Errorf("version %q was accepted", version)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var _ net.Conn = &websocketConn{}
+
+// websocketConn carries each JSON-RPC line as a WebSocket text message
+type websocketConn struct {
+	wsConn      *websocket.Conn
+	tlsState    *tls.ConnectionState
+	reader      io.Reader
+	writeLocker sync.Mutex
+}
+
+func (c *websocketConn) Read(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	for {
+		if c.reader == nil {
+			_, reader, err := c.wsConn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+
+			c.reader = reader
+		}
+
+		n, err = c.reader.Read(b)
+		if n > 0 {
+			return n, nil
+		}
+
+		// Terminate the message as a line
+		if errors.Is(err, io.EOF) {
+			c.reader = nil
+			b[0] = '\n'
+
+			return 1, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *websocketConn) Write(b []byte) (n int, err error) {
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+
+	for _, line := range bytes.Split(b, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := c.wsConn.WriteMessage(websocket.TextMessage, line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (c *websocketConn) Close() error {
+	return c.wsConn.Close()
+}
+
+func (c *websocketConn) LocalAddr() net.Addr {
+	return c.wsConn.LocalAddr()
+}
+
+func (c *websocketConn) RemoteAddr() net.Addr {
+	return c.wsConn.RemoteAddr()
+}
+
+func (c *websocketConn) SetDeadline(t time.Time) error {
+	if err := c.wsConn.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.wsConn.SetWriteDeadline(t)
+}
+
+func (c *websocketConn) SetReadDeadline(t time.Time) error {
+	return c.wsConn.SetReadDeadline(t)
+}
+
+func (c *websocketConn) SetWriteDeadline(t time.Time) error {
+	return c.wsConn.SetWriteDeadline(t)
+}
+
+// ConnectionState returns the TLS state of the underlying HTTP connection,
+// it's empty for plain ws connections
+func (c *websocketConn) ConnectionState() tls.ConnectionState {
+	if c.tlsState == nil {
+		return tls.ConnectionState{}
+	}
+
+	return *c.tlsState
+}
+
+var _ net.Listener = &websocketListener{}
+
+type websocketListener struct {
+	listener  net.Listener
+	server    *http.Server
+	upgrader  websocket.Upgrader
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *websocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *websocketListener) Close() error {
+	var err error
+
+	l.closeOnce.Do(func() {
+		close(l.done)
+
+		err = l.server.Close()
+	})
+
+	return err
+}
+
+func (l *websocketListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *websocketListener) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	wsConn, err := l.upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		return
+	}
+
+	conn := websocketConn{
+		wsConn:   wsConn,
+		tlsState: request.TLS,
+	}
+
+	select {
+	case l.conns <- &conn:
+	case <-l.done:
+		_ = wsConn.Close()
+	}
+}
+
+// ListenWebSocket accepts WebSocket connections on the path, wrap the listener with TLS for wss
+func ListenWebSocket(listener net.Listener, path string) net.Listener {
+	if path == "" {
+		path = "/"
+	}
+
+	wsListener := websocketListener{
+		listener: listener,
+		upgrader: websocket.Upgrader{
+			// Miners are not browsers sharing cookies, any origin is welcome
+			CheckOrigin: func(_ *http.Request) bool {
+				return true
+			},
+		},
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, &wsListener)
+
+	wsListener.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: DefaultDialTimeout,
+	}
+
+	go func() {
+		_ = wsListener.server.Serve(listener)
+
+		_ = wsListener.Close()
+	}()
+
+	return &wsListener
+}
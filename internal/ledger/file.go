@@ -0,0 +1,129 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var _ Sink = &fileSink{}
+
+// fileSink appends shares as JSON lines, the file is renamed with a timestamp suffix once it's too large
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// countingWriter counts the bytes that reach the file, bufio flushes full buffers on its own
+type countingWriter struct {
+	file *os.File
+	size *int64
+}
+
+func (w countingWriter) Write(b []byte) (int, error) {
+	n, err := w.file.Write(b)
+	*w.size += int64(n)
+
+	return n, err
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+
+		return err
+	}
+
+	s.file, s.size = file, info.Size()
+
+	return nil
+}
+
+func (s *fileSink) Write(shares []Share) error {
+	if s.maxSize > 0 && s.size >= s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	writer := bufio.NewWriter(countingWriter{file: s.file, size: &s.size})
+	encoder := json.NewEncoder(writer)
+
+	for _, share := range shares {
+		if err := encoder.Encode(share); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(s.path, s.path+"."+time.Now().UTC().Format("20060102T150405.000")); err != nil {
+		return err
+	}
+
+	if s.maxBackups > 0 {
+		backups, err := Backups(s.path)
+		if err != nil {
+			return err
+		}
+
+		// Timestamps sort in time order, the oldest go first
+		for len(backups) > s.maxBackups {
+			if err := os.Remove(backups[0]); err != nil {
+				return err
+			}
+
+			backups = backups[1:]
+		}
+	}
+
+	return s.open()
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+// Backups returns the rotated files of the path, oldest first
+func Backups(path string) ([]string, error) {
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(backups)
+
+	return backups, nil
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) (*fileSink, error) {
+	sink := fileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+
+	return &sink, nil
+}
@@ -0,0 +1,66 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares.log")
+
+	sink, err := newFileSink(path, 4096, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sink.Close()
+
+	// A batch is larger than the buffer of bufio, so most of it is written before the flush
+	batch := make([]Share, maxBatchSize)
+	for i := range batch {
+		batch[i] = Share{Time: time.Now().UTC(), Wallet: "0xabc", Worker: "rig01", Route: "origin", Result: ResultAccepted}
+	}
+
+	var previous []string
+
+	for i := 0; i < 4; i++ {
+		// Backups are named by the millisecond of their rotation
+		time.Sleep(time.Millisecond * 2)
+
+		if err := sink.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if sink.size != info.Size() {
+			t.Fatalf("tracked %d bytes, %d are on disk", sink.size, info.Size())
+		}
+
+		// Every batch exceeds the limit, so every later batch starts a new file
+		backups, err := Backups(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := i
+		if want > 2 {
+			want = 2
+		}
+
+		if len(backups) != want {
+			t.Fatalf("%d backups after %d batches, want %d", len(backups), i+1, want)
+		}
+
+		if i == 3 && backups[0] == previous[0] {
+			t.Errorf("oldest backup %s wasn't pruned", previous[0])
+		}
+
+		previous = backups
+	}
+}
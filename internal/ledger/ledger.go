@@ -0,0 +1,164 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tier2pool/tier2pool/internal/metrics"
+)
+
+const (
+	SinkFile     = "file"
+	SinkSQLite   = "sqlite"
+	SinkPostgres = "postgres"
+
+	ResultAccepted = "accepted"
+	ResultRejected = "rejected"
+	ResultUnknown  = "unknown"
+
+	DefaultBufferSize = 4096
+
+	// Shares are written in batches of at most this size, or every flushInterval
+	maxBatchSize  = 256
+	flushInterval = time.Second
+)
+
+// Share is one submitted share and what its pool answered
+type Share struct {
+	Time       time.Time `json:"time"`
+	Session    string    `json:"session"`
+	Wallet     string    `json:"wallet"`
+	Worker     string    `json:"worker"`
+	JobID      string    `json:"job_id"`
+	Route      string    `json:"route"`
+	Difficulty float64   `json:"difficulty"`
+	Result     string    `json:"result"`
+}
+
+// Sink persists batches of shares, it's only called by the ledger's own goroutine
+type Sink interface {
+	Write(shares []Share) error
+	Close() error
+}
+
+type Option struct {
+	// Sink is one of file, sqlite and postgres
+	Sink string
+	// Path is the file of the file and sqlite sinks
+	Path string
+	// DSN is the connection string of the postgres sink
+	DSN string
+	// Timescale turns the postgres table into a hypertable
+	Timescale bool
+	// MaxSize rotates the file of the file sink once it's larger in bytes, zero means never
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept, zero means all of them
+	MaxBackups int
+	// BufferSize is the number of shares waiting to be written, zero means DefaultBufferSize
+	BufferSize int
+}
+
+// Ledger writes shares asynchronously, a share is dropped rather than delaying the session when the buffer is full
+type Ledger struct {
+	sink   Sink
+	shares chan Share
+	done   chan struct{}
+}
+
+// Record queues the share without blocking
+func (l *Ledger) Record(share Share) {
+	select {
+	case l.shares <- share:
+	default:
+		metrics.LedgerDropped.Inc()
+	}
+}
+
+// Run writes the queued shares until the context is done, then writes what's left and closes the sink
+func (l *Ledger) Run(ctx context.Context) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Share, 0, maxBatchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := l.sink.Write(batch); err != nil {
+			logrus.Errorf("failed to write %d shares to the ledger: %s", len(batch), err)
+
+			metrics.LedgerDropped.Add(float64(len(batch)))
+		}
+
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case share := <-l.shares:
+			if batch = append(batch, share); len(batch) >= maxBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case share := <-l.shares:
+					batch = append(batch, share)
+				default:
+					flush()
+
+					if err := l.sink.Close(); err != nil {
+						logrus.Error(err)
+					}
+
+					return
+				}
+			}
+		}
+	}
+}
+
+// Done is closed once Run has written everything and closed the sink
+func (l *Ledger) Done() <-chan struct{} {
+	return l.done
+}
+
+func New(option Option) (*Ledger, error) {
+	if option.BufferSize == 0 {
+		option.BufferSize = DefaultBufferSize
+	}
+
+	var (
+		sink Sink
+		err  error
+	)
+
+	switch option.Sink {
+	case SinkFile:
+		sink, err = newFileSink(option.Path, option.MaxSize, option.MaxBackups)
+	case SinkSQLite:
+		sink, err = newSQLiteSink(option.Path)
+	case SinkPostgres:
+		sink, err = newPostgresSink(option.DSN, option.Timescale)
+	default:
+		return nil, fmt.Errorf("%s ledger isn't supported", option.Sink)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ledger{
+		sink:   sink,
+		shares: make(chan Share, option.BufferSize),
+		done:   make(chan struct{}),
+	}, nil
+}
@@ -0,0 +1,76 @@
+package ledger
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func record(t *testing.T, option Option, shares []Share) {
+	t.Helper()
+
+	ledger, err := New(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go ledger.Run(ctx)
+
+	for _, share := range shares {
+		ledger.Record(share)
+	}
+
+	// Shares still queued when the context is done must be written before Done
+	cancel()
+
+	select {
+	case <-ledger.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("ledger didn't finish after its context was done")
+	}
+}
+
+func testRoundTrip(t *testing.T, option Option) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	shares := []Share{
+		{Time: now.Add(-time.Hour), Wallet: "0xabc", Worker: "rig01", Route: "origin", Difficulty: 4, Result: ResultAccepted},
+		{Time: now, Wallet: "0xabc", Worker: "rig01", Route: "origin", Difficulty: 4, Result: ResultRejected},
+		{Time: now.Add(time.Minute), Wallet: "0xabc", Worker: "rig02", Route: "fee", Difficulty: 2, Result: ResultAccepted},
+	}
+
+	record(t, option, shares)
+
+	var read []Share
+	if err := Read(option, now, now.Add(time.Minute), func(share Share) error {
+		read = append(read, share)
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(read) != 1 {
+		t.Fatalf("read %d shares in range, want 1", len(read))
+	}
+
+	if share := read[0]; !share.Time.Equal(now) || share.Worker != "rig01" || share.Difficulty != 4 || share.Result != ResultRejected {
+		t.Fatalf("read %+v, want %+v", share, shares[1])
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	testRoundTrip(t, Option{
+		Sink: SinkFile,
+		Path: filepath.Join(t.TempDir(), "shares.log"),
+	})
+}
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	testRoundTrip(t, Option{
+		Sink: SinkSQLite,
+		Path: filepath.Join(t.TempDir(), "shares.db"),
+	})
+}
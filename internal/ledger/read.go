@@ -0,0 +1,115 @@
+package ledger
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Read passes every share recorded in [since, until) to the handler, a zero time leaves its end open
+func Read(option Option, since, until time.Time, handler func(share Share) error) error {
+	inRange := func(t time.Time) bool {
+		return !t.Before(since) && (until.IsZero() || t.Before(until))
+	}
+
+	switch option.Sink {
+	case SinkFile:
+		backups, err := Backups(option.Path)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range append(backups, option.Path) {
+			if err := readFile(path, func(share Share) error {
+				if !inRange(share.Time) {
+					return nil
+				}
+
+				return handler(share)
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case SinkSQLite:
+		return readSQL(DriverSQLite, option.Path, since, until, handler)
+	case SinkPostgres:
+		return readSQL(DriverPostgres, option.DSN, since, until, handler)
+	default:
+		return fmt.Errorf("%s ledger isn't supported", option.Sink)
+	}
+}
+
+func readFile(path string, handler func(share Share) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		// A line may be cut short by a crash, the rest of the file is still readable
+		share := Share{}
+		if err := json.Unmarshal(scanner.Bytes(), &share); err != nil {
+			continue
+		}
+
+		if err := handler(share); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func readSQL(driver, dsn string, since, until time.Time, handler func(share Share) error) error {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	conditions := []string{"time >= " + Placeholder(driver, 1)}
+	args := []interface{}{since.UTC()}
+
+	if !until.IsZero() {
+		conditions = append(conditions, "time < "+Placeholder(driver, 2))
+		args = append(args, until.UTC())
+	}
+
+	rows, err := db.Query(fmt.Sprintf(
+		"SELECT time, session, wallet, worker, job_id, route, difficulty, result FROM %s WHERE %s",
+		Table, strings.Join(conditions, " AND "),
+	), args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		share := Share{}
+
+		if err := rows.Scan(
+			&share.Time, &share.Session, &share.Wallet, &share.Worker,
+			&share.JobID, &share.Route, &share.Difficulty, &share.Result,
+		); err != nil {
+			return err
+		}
+
+		if err := handler(share); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,130 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+
+	// Table is where the sql sinks keep the shares
+	Table = "shares"
+)
+
+var _ Sink = &sqlSink{}
+
+// sqlSink inserts every batch in one transaction
+type sqlSink struct {
+	db     *sql.DB
+	insert string
+}
+
+func (s *sqlSink) Write(shares []Share) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	statement, err := tx.Prepare(s.insert)
+	if err != nil {
+		_ = tx.Rollback()
+
+		return err
+	}
+
+	for _, share := range shares {
+		if _, err := statement.Exec(
+			share.Time, share.Session, share.Wallet, share.Worker,
+			share.JobID, share.Route, share.Difficulty, share.Result,
+		); err != nil {
+			_ = statement.Close()
+			_ = tx.Rollback()
+
+			return err
+		}
+	}
+
+	_ = statement.Close()
+
+	return tx.Commit()
+}
+
+func (s *sqlSink) Close() error {
+	return s.db.Close()
+}
+
+func newSQLiteSink(path string) (*sqlSink, error) {
+	db, err := sql.Open(DriverSQLite, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite has a single writer anyway
+	db.SetMaxOpenConns(1)
+
+	return newSQLSink(db, DriverSQLite, "TIMESTAMP", "REAL", nil)
+}
+
+func newPostgresSink(dsn string, timescale bool) (*sqlSink, error) {
+	db, err := sql.Open(DriverPostgres, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []string
+
+	if timescale {
+		statements = append(statements, fmt.Sprintf("SELECT create_hypertable('%s', 'time', if_not_exists => TRUE)", Table))
+	}
+
+	return newSQLSink(db, DriverPostgres, "TIMESTAMPTZ", "DOUBLE PRECISION", statements)
+}
+
+func newSQLSink(db *sql.DB, driver, timestamp, float string, statements []string) (*sqlSink, error) {
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	time %s NOT NULL,
+	session TEXT NOT NULL,
+	wallet TEXT NOT NULL,
+	worker TEXT NOT NULL,
+	job_id TEXT NOT NULL,
+	route TEXT NOT NULL,
+	difficulty %s NOT NULL,
+	result TEXT NOT NULL
+)`, Table, timestamp, float)
+
+	for _, statement := range append([]string{create}, statements...) {
+		if _, err := db.Exec(statement); err != nil {
+			_ = db.Close()
+
+			return nil, err
+		}
+	}
+
+	placeholders := make([]string, 8)
+	for i := range placeholders {
+		placeholders[i] = Placeholder(driver, i+1)
+	}
+
+	return &sqlSink{
+		db: db,
+		insert: fmt.Sprintf(
+			"INSERT INTO %s (time, session, wallet, worker, job_id, route, difficulty, result) VALUES (%s)",
+			Table, strings.Join(placeholders, ", "),
+		),
+	}, nil
+}
+
+// Placeholder returns the nth parameter of a statement in the driver's syntax
+func Placeholder(driver string, n int) string {
+	if driver == DriverPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+
+	return "?"
+}
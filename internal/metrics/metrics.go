@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "tier2pool"
+
+	LabelPool  = "pool"
+	LabelRoute = "route"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	PoolConnectLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_connect_latency_seconds",
+		Help:      "Smoothed time to establish a connection to the pool.",
+	}, []string{LabelPool})
+
+	PoolShareLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_share_latency_seconds",
+		Help:      "Smoothed time between forwarding a share and receiving its result.",
+	}, []string{LabelPool})
+
+	PoolHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_healthy",
+		Help:      "Whether the last probe of the pool succeeded.",
+	}, []string{LabelPool})
+
+	PoolSessions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pool_sessions_total",
+		Help:      "Sessions assigned to the pool.",
+	}, []string{LabelPool})
+
+	RouteSwitches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "route_switches_total",
+		Help:      "Times a miner was switched to the jobs of the route.",
+	}, []string{LabelRoute})
+
+	RouteDifficulty = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "route_difficulty_total",
+		Help:      "Difficulty of the shares found on the jobs of the route.",
+	}, []string{LabelRoute})
+
+	LedgerDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ledger_dropped_shares_total",
+		Help:      "Shares that couldn't be written to the ledger.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		PoolConnectLatency,
+		PoolShareLatency,
+		PoolHealthy,
+		PoolSessions,
+		RouteSwitches,
+		RouteDifficulty,
+		LedgerDropped,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
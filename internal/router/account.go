@@ -0,0 +1,94 @@
+package router
+
+import (
+	"sort"
+	"sync"
+)
+
+// Account sums the work found on the jobs of each route by difficulty, along with the work each fee route
+// would have got at its weight, so that sessions with different weights can be added up into one account
+type Account struct {
+	work     map[string]float64
+	expected map[string]float64
+	total    float64
+	locker   sync.Mutex
+}
+
+type Report struct {
+	Route string `json:"route"`
+	// Target is the configured ratio of the work, Achieved is the ratio actually found on the route's jobs
+	Target     float64 `json:"target"`
+	Achieved   float64 `json:"achieved"`
+	Difficulty float64 `json:"difficulty"`
+}
+
+// Add accounts a share of the route, a share without difficulty counts as one
+func (a *Account) Add(route string, difficulty float64, weights map[string]float64) {
+	if difficulty <= 0 {
+		difficulty = 1
+	}
+
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	a.work[route] += difficulty
+	a.total += difficulty
+
+	for feeRoute, weight := range weights {
+		a.expected[feeRoute] += difficulty * weight
+	}
+}
+
+// Ratio returns the achieved ratio of the route, zero before any work
+func (a *Account) Ratio(route string) float64 {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	return a.ratio(route)
+}
+
+func (a *Account) ratio(route string) float64 {
+	if a.total == 0 {
+		return 0
+	}
+
+	return a.work[route] / a.total
+}
+
+// Total returns the difficulty of all routes
+func (a *Account) Total() float64 {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	return a.total
+}
+
+// Report returns the target and achieved ratio of every fee route, sorted by route
+func (a *Account) Report() []Report {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	reports := make([]Report, 0, len(a.expected))
+
+	for route, expected := range a.expected {
+		reports = append(reports, Report{
+			Route:      route,
+			Target:     expected / a.total,
+			Achieved:   a.ratio(route),
+			Difficulty: a.work[route],
+		})
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Route < reports[j].Route
+	})
+
+	return reports
+}
+
+func NewAccount() *Account {
+	return &Account{
+		work:     make(map[string]float64),
+		expected: make(map[string]float64),
+	}
+}
@@ -0,0 +1,45 @@
+package router
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const (
+	Thread     = 3             // Correction probability
+	WeightUnit = 1000 * Thread // 1‰
+)
+
+var _ Router = &randomRouter{}
+
+// randomRouter draws against the weight on every job of a fee route, origin jobs are always accepted
+type randomRouter struct {
+	weights map[string]int64
+}
+
+func (r *randomRouter) Accept(route string) bool {
+	if route == RouteOrigin {
+		return true
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(WeightUnit))
+	if err != nil {
+		return false
+	}
+
+	return n.Int64() < r.weights[route]
+}
+
+func (r *randomRouter) Submitted(_ string, _ float64) {}
+
+func newRandomRouter(weights map[string]float64) *randomRouter {
+	router := randomRouter{
+		weights: make(map[string]int64, len(weights)),
+	}
+
+	for route, weight := range weights {
+		router.weights[route] = int64(float64(WeightUnit) * weight)
+	}
+
+	return &router
+}
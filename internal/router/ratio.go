@@ -0,0 +1,67 @@
+package router
+
+import (
+	"sync"
+)
+
+var _ Router = &ratioRouter{}
+
+// ratioRouter accepts jobs of a fee route while its share of the submitted work is behind its weight,
+// work is counted in shares or in difficulty
+type ratioRouter struct {
+	weights      map[string]float64
+	byDifficulty bool
+	work         map[string]float64
+	total        float64
+	count        int
+	locker       sync.Mutex
+}
+
+// behind reports whether the next share of the route would still keep it at or below its weight
+func (r *ratioRouter) behind(route string) bool {
+	unit := 1.0
+	if r.byDifficulty && r.count > 0 {
+		unit = r.total / float64(r.count)
+	}
+
+	return r.work[route]+unit <= r.weights[route]*(r.total+unit)
+}
+
+func (r *ratioRouter) Accept(route string) bool {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if route != RouteOrigin {
+		return r.behind(route)
+	}
+
+	for feeRoute := range r.weights {
+		if r.behind(feeRoute) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *ratioRouter) Submitted(route string, difficulty float64) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	work := 1.0
+	if r.byDifficulty && difficulty > 0 {
+		work = difficulty
+	}
+
+	r.work[route] += work
+	r.total += work
+	r.count++
+}
+
+func newRatioRouter(weights map[string]float64, byDifficulty bool) *ratioRouter {
+	return &ratioRouter{
+		weights:      weights,
+		byDifficulty: byDifficulty,
+		work:         make(map[string]float64, len(weights)+1),
+	}
+}
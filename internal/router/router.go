@@ -0,0 +1,91 @@
+package router
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	RouteOrigin  = "origin"
+	RouteInject  = "inject"
+	RouteDevelop = "develop"
+
+	NameRandom     = "random"
+	NameTimeSlice  = "timeslice"
+	NameShares     = "shares"
+	NameDifficulty = "difficulty"
+
+	DefaultPeriod = time.Hour
+)
+
+// Scheduler is implemented by routers that give the miner to one route at a time,
+// the session switches as soon as the current route changes instead of waiting for the next job
+type Scheduler interface {
+	// Current returns the route the miner should be working for
+	Current() string
+	// Upcoming returns the fee routes whose window overlaps the coming duration, their upstreams are opened ahead
+	Upcoming(within time.Duration) []string
+}
+
+// Router decides which upstream's jobs the miner works on, it's created per session
+// and must be safe for concurrent use because every upstream is read by its own goroutine
+type Router interface {
+	// Accept reports whether a job notified by the route is sent to the miner
+	Accept(route string) bool
+	// Submitted accounts a share found on a job of the route
+	Submitted(route string, difficulty float64)
+}
+
+type Option struct {
+	// Name is one of random, timeslice, shares and difficulty, empty means random
+	Name string
+	// Weights are the ratios of the fee routes, the origin pool gets the rest
+	Weights map[string]float64
+	// Period is the cycle of the timeslice router, zero means DefaultPeriod
+	Period time.Duration
+}
+
+func New(option Option) (Router, error) {
+	if option.Period < 0 {
+		return nil, fmt.Errorf("period %s is negative", option.Period)
+	}
+
+	total := 0.0
+
+	for route, weight := range option.Weights {
+		if weight < 0 || weight > 1 {
+			return nil, fmt.Errorf("weight %f of %s is out of range", weight, route)
+		}
+
+		total += weight
+	}
+
+	if total > 1 {
+		return nil, fmt.Errorf("total weight %f is greater than 1", total)
+	}
+
+	// Routers keep the weights, they must not change under them
+	weights := make(map[string]float64, len(option.Weights))
+	for route, weight := range option.Weights {
+		weights[route] = weight
+	}
+
+	option.Weights = weights
+
+	switch option.Name {
+	case "", NameRandom:
+		return newRandomRouter(option.Weights), nil
+	case NameTimeSlice:
+		if option.Period == 0 {
+			option.Period = DefaultPeriod
+		}
+
+		return newTimeSliceRouter(option.Weights, option.Period), nil
+	case NameShares:
+		return newRatioRouter(option.Weights, false), nil
+	case NameDifficulty:
+		return newRatioRouter(option.Weights, true), nil
+	default:
+		return nil, fmt.Errorf("%s router isn't supported", option.Name)
+	}
+}
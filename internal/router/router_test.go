@@ -0,0 +1,320 @@
+package router
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		option Option
+		valid  bool
+	}{
+		{"default", Option{Weights: map[string]float64{RouteInject: 0.1}}, true},
+		{"random", Option{Name: NameRandom, Weights: map[string]float64{RouteInject: 0.1}}, true},
+		{"timeslice", Option{Name: NameTimeSlice, Weights: map[string]float64{RouteInject: 0.1}, Period: time.Minute}, true},
+		{"shares", Option{Name: NameShares, Weights: map[string]float64{RouteInject: 0.1}}, true},
+		{"difficulty", Option{Name: NameDifficulty, Weights: map[string]float64{RouteInject: 0.1}}, true},
+		{"unknown name", Option{Name: "roundrobin"}, false},
+		{"negative weight", Option{Name: NameRandom, Weights: map[string]float64{RouteInject: -0.1}}, false},
+		{"weight above one", Option{Name: NameRandom, Weights: map[string]float64{RouteInject: 1.1}}, false},
+		{"total above one", Option{Name: NameShares, Weights: map[string]float64{RouteInject: 0.6, RouteDevelop: 0.6}}, false},
+		{"negative period", Option{Name: NameTimeSlice, Period: -time.Second}, false},
+	}
+
+	for _, test := range tests {
+		if _, err := New(test.option); (err == nil) != test.valid {
+			t.Errorf("%s: valid = %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	router, err := New(Option{Weights: map[string]float64{RouteInject: 0.1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Time slicing is opt-in, deployments without a router keep the random draw
+	if _, ok := router.(*randomRouter); !ok {
+		t.Errorf("expected the random router by default, got %T", router)
+	}
+}
+
+func TestNewCopiesWeights(t *testing.T) {
+	weights := map[string]float64{RouteInject: 0.5}
+
+	router, err := New(Option{Name: NameShares, Weights: weights})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weights[RouteInject] = 0
+
+	router.Submitted(RouteOrigin, 1)
+
+	if !router.Accept(RouteInject) {
+		t.Error("the router follows the caller's weights")
+	}
+}
+
+// simulate submits a share on every job the router accepts and returns the ratio of work per route,
+// difficulties are the work of a share of each route, one if missing
+func simulate(router Router, shares int, difficulties map[string]float64) map[string]float64 {
+	work := make(map[string]float64)
+	total := 0.0
+
+	for i := 0; i < shares; i++ {
+		route := RouteOrigin
+
+		for _, feeRoute := range []string{RouteDevelop, RouteInject} {
+			if router.Accept(feeRoute) {
+				route = feeRoute
+
+				break
+			}
+		}
+
+		difficulty, ok := difficulties[route]
+		if !ok {
+			difficulty = 1
+		}
+
+		router.Submitted(route, difficulty)
+
+		work[route] += difficulty
+		total += difficulty
+	}
+
+	for route := range work {
+		work[route] /= total
+	}
+
+	return work
+}
+
+func TestRandomRouter(t *testing.T) {
+	router := newRandomRouter(map[string]float64{RouteInject: 0.1, RouteDevelop: 0})
+
+	for i := 0; i < 100; i++ {
+		if !router.Accept(RouteOrigin) {
+			t.Fatal("origin jobs must always be accepted")
+		}
+
+		if router.Accept(RouteDevelop) {
+			t.Fatal("a route without weight must never be accepted")
+		}
+	}
+
+	accepted := 0
+
+	for i := 0; i < 20000; i++ {
+		if router.Accept(RouteInject) {
+			accepted++
+		}
+	}
+
+	if ratio := float64(accepted) / 20000; math.Abs(ratio-0.1) > 0.02 {
+		t.Errorf("expected about 10%% accepted, got %.2f%%", ratio*100)
+	}
+}
+
+func TestRatioRouterShares(t *testing.T) {
+	router := newRatioRouter(map[string]float64{RouteInject: 0.1, RouteDevelop: 0.01}, false)
+
+	work := simulate(router, 10000, nil)
+
+	if math.Abs(work[RouteInject]-0.1) > 0.001 || math.Abs(work[RouteDevelop]-0.01) > 0.001 {
+		t.Errorf("expected 10%% and 1%%, got %v", work)
+	}
+}
+
+func TestRatioRouterOrigin(t *testing.T) {
+	router := newRatioRouter(map[string]float64{RouteInject: 0.5}, false)
+
+	// A fee share would be more than the weight of the first share
+	if !router.Accept(RouteOrigin) || router.Accept(RouteInject) {
+		t.Error("the session should start on the origin")
+	}
+
+	router.Submitted(RouteOrigin, 1)
+
+	// The fee route is behind, origin jobs are held back until it catches up
+	if router.Accept(RouteOrigin) || !router.Accept(RouteInject) {
+		t.Error("origin jobs are accepted while the fee route is behind")
+	}
+
+	router.Submitted(RouteInject, 1)
+
+	if !router.Accept(RouteOrigin) || router.Accept(RouteInject) {
+		t.Error("the fee route is ahead, only origin jobs should be accepted")
+	}
+}
+
+func TestRatioRouterDifficulty(t *testing.T) {
+	// The fee pool's shares are worth four origin shares
+	difficulties := map[string]float64{RouteInject: 4}
+
+	byShares := simulate(newRatioRouter(map[string]float64{RouteInject: 0.1}, false), 10000, difficulties)
+	byDifficulty := simulate(newRatioRouter(map[string]float64{RouteInject: 0.1}, true), 10000, difficulties)
+
+	if math.Abs(byDifficulty[RouteInject]-0.1) > 0.01 {
+		t.Errorf("expected 10%% of the difficulty, got %.2f%%", byDifficulty[RouteInject]*100)
+	}
+
+	// Counting shares diverts far more work than the weight
+	if byShares[RouteInject] < 0.2 {
+		t.Errorf("expected the share router to overshoot, got %.2f%%", byShares[RouteInject]*100)
+	}
+}
+
+// clock is a fake time source of the timeslice router
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestTimeSliceRouter(weights map[string]float64, period time.Duration) (*timeSliceRouter, *clock) {
+	c := clock{now: time.Unix(0, 0)}
+
+	router := newTimeSliceRouter(weights, period)
+	router.now = c.Now
+	router.phase = 0
+
+	return router, &c
+}
+
+func TestTimeSliceWindows(t *testing.T) {
+	router, c := newTestTimeSliceRouter(map[string]float64{RouteInject: 0.1, RouteDevelop: 0.05}, time.Second*100)
+
+	// Windows follow the sorted routes from the start of the period, the origin gets the rest
+	expected := []struct {
+		at    time.Duration
+		route string
+	}{
+		{0, RouteDevelop},
+		{time.Second * 4, RouteDevelop},
+		{time.Second * 5, RouteInject},
+		{time.Second * 14, RouteInject},
+		{time.Second * 15, RouteOrigin},
+		{time.Second * 99, RouteOrigin},
+		{time.Second * 100, RouteDevelop},
+	}
+
+	for _, e := range expected {
+		c.now = time.Unix(0, 0).Add(e.at)
+
+		if route := router.Current(); route != e.route {
+			t.Errorf("at %s expected %s, got %s", e.at, e.route, route)
+		}
+
+		if !router.Accept(e.route) {
+			t.Errorf("at %s the current route %s isn't accepted", e.at, e.route)
+		}
+
+		for _, other := range []string{RouteOrigin, RouteInject, RouteDevelop} {
+			if other != e.route && router.Accept(other) {
+				t.Errorf("at %s %s is accepted besides %s", e.at, other, e.route)
+			}
+		}
+	}
+}
+
+func TestTimeSliceUpcoming(t *testing.T) {
+	router, c := newTestTimeSliceRouter(map[string]float64{RouteInject: 0.1, RouteDevelop: 0.05}, time.Second*100)
+
+	// Develop owns [0s, 5s) and inject [5s, 15s) of every period
+	expected := []struct {
+		at     time.Duration
+		within time.Duration
+		routes []string
+	}{
+		{0, time.Second, []string{RouteDevelop}},
+		{time.Second * 3, time.Second * 3, []string{RouteDevelop, RouteInject}},
+		{time.Second * 20, time.Second * 30, []string{}},
+		{time.Second * 90, time.Second * 5, []string{}},
+		{time.Second * 97, time.Second * 5, []string{RouteDevelop}},
+		{time.Second * 80, time.Second * 30, []string{RouteDevelop, RouteInject}},
+		{time.Second * 20, time.Second * 100, []string{RouteDevelop, RouteInject}},
+	}
+
+	for _, e := range expected {
+		c.now = time.Unix(0, 0).Add(e.at)
+		router.Current()
+
+		routes := router.Upcoming(e.within)
+		if strings.Join(routes, ",") != strings.Join(e.routes, ",") {
+			t.Errorf("at %s within %s expected %v, got %v", e.at, e.within, e.routes, routes)
+		}
+	}
+}
+
+func TestTimeSliceSelfCorrecting(t *testing.T) {
+	period := time.Minute
+	router, c := newTestTimeSliceRouter(map[string]float64{RouteInject: 0.05}, period)
+
+	// The fee pool finds half the work per second of the origin pool
+	difficulties := map[string]float64{RouteOrigin: 1, RouteInject: 0.5}
+
+	for i := 0; i < 200*60; i++ {
+		c.now = c.now.Add(time.Second)
+
+		route := router.Current()
+		router.Submitted(route, difficulties[route])
+	}
+
+	if ratio := router.account.Ratio(RouteInject); math.Abs(ratio-0.05) > 0.005 {
+		t.Errorf("expected the work to converge on 5%%, got %.2f%%", ratio*100)
+	}
+}
+
+func TestPhase(t *testing.T) {
+	weights := map[string]float64{RouteInject: 0.1}
+
+	for i := 0; i < 10; i++ {
+		router := newTimeSliceRouter(weights, time.Minute)
+
+		if router.phase < 0 || router.phase >= time.Minute {
+			t.Fatalf("phase %s is out of the period", router.phase)
+		}
+	}
+}
+
+func TestAccount(t *testing.T) {
+	account := NewAccount()
+	weights := map[string]float64{RouteInject: 0.1}
+
+	account.Add(RouteOrigin, 9, weights)
+	account.Add(RouteInject, 1, weights)
+
+	// A share without difficulty counts as one
+	account.Add(RouteInject, 0, weights)
+
+	if total := account.Total(); total != 11 {
+		t.Errorf("expected total 11, got %f", total)
+	}
+
+	if ratio := account.Ratio(RouteInject); math.Abs(ratio-2.0/11) > 1e-9 {
+		t.Errorf("expected ratio 2/11, got %f", ratio)
+	}
+
+	reports := account.Report()
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %v", reports)
+	}
+
+	report := reports[0]
+	if report.Route != RouteInject || math.Abs(report.Target-0.1) > 1e-9 || report.Difficulty != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	if ratio := NewAccount().Ratio(RouteInject); ratio != 0 {
+		t.Errorf("expected 0 before any work, got %f", ratio)
+	}
+}
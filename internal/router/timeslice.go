@@ -0,0 +1,173 @@
+package router
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	_ Router    = &timeSliceRouter{}
+	_ Scheduler = &timeSliceRouter{}
+)
+
+type timeSlice struct {
+	route string
+	end   time.Duration
+}
+
+// timeSliceRouter gives every fee route a contiguous window of each period proportional to its weight,
+// the phase is random per session so that sessions don't switch all at once.
+// Pools differ in difficulty and notify rate, so the work found in a window isn't exactly its length,
+// at the start of every period the windows are resized to bring the work of the session back to the weights
+type timeSliceRouter struct {
+	weights map[string]float64
+	routes  []string
+	period  time.Duration
+	phase   time.Duration
+	now     func() time.Time
+	account *Account
+
+	// cycle is the index of the period the slices were computed for, cycles counts the periods with work
+	slices []timeSlice
+	cycle  int64
+	cycles int
+	locker sync.Mutex
+}
+
+// Current returns the route owning the moment
+func (r *timeSliceRouter) Current() string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	moment := r.now().UnixNano() + int64(r.phase)
+	position := time.Duration(moment % int64(r.period))
+
+	if cycle := moment / int64(r.period); cycle != r.cycle {
+		if r.cycle >= 0 && r.account.Total() > 0 {
+			r.cycles++
+		}
+
+		r.cycle = cycle
+		r.slices = r.schedule()
+	}
+
+	for _, slice := range r.slices {
+		if position < slice.end {
+			return slice.route
+		}
+	}
+
+	return RouteOrigin
+}
+
+// Upcoming looks ahead in the slices of the current period, the windows of the next one are resized once it starts
+func (r *timeSliceRouter) Upcoming(within time.Duration) []string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	moment := r.now().UnixNano() + int64(r.phase)
+	from := time.Duration(moment % int64(r.period))
+	to := from + within
+
+	routes := make([]string, 0, len(r.slices))
+
+	var start time.Duration
+
+	for _, slice := range r.slices {
+		// The coming duration may wrap around to the start of the period
+		overlaps := start < to && from < slice.end || start < to-r.period
+		if slice.end > start && (overlaps || within >= r.period) {
+			routes = append(routes, slice.route)
+		}
+
+		start = slice.end
+	}
+
+	return routes
+}
+
+func (r *timeSliceRouter) Accept(route string) bool {
+	return r.Current() == route
+}
+
+func (r *timeSliceRouter) Submitted(route string, difficulty float64) {
+	r.account.Add(route, difficulty, r.weights)
+}
+
+// schedule sizes the windows of the next period, expecting as much work as the average past period,
+// so that the session's ratio of every fee route reaches its weight by the end of it
+func (r *timeSliceRouter) schedule() []timeSlice {
+	ratios := make(map[string]float64, len(r.routes))
+
+	total := r.account.Total()
+	sum := 0.0
+
+	for _, route := range r.routes {
+		ratio := r.weights[route]
+
+		if r.cycles > 0 && total > 0 {
+			work := r.account.Ratio(route) * total
+			next := total / float64(r.cycles)
+
+			ratio = math.Max(0, math.Min(1, (r.weights[route]*(total+next)-work)/next))
+		}
+
+		ratios[route] = ratio
+		sum += ratio
+	}
+
+	// Catching up can't take more than the whole period
+	if sum > 1 {
+		for route := range ratios {
+			ratios[route] /= sum
+		}
+	}
+
+	slices := make([]timeSlice, 0, len(r.routes))
+
+	var end time.Duration
+
+	for _, route := range r.routes {
+		end += time.Duration(float64(r.period) * ratios[route])
+
+		slices = append(slices, timeSlice{
+			route: route,
+			end:   end,
+		})
+	}
+
+	return slices
+}
+
+var (
+	phaseRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	phaseLocker sync.Mutex
+)
+
+func newTimeSliceRouter(weights map[string]float64, period time.Duration) *timeSliceRouter {
+	routes := make([]string, 0, len(weights))
+
+	for route := range weights {
+		routes = append(routes, route)
+	}
+
+	sort.Strings(routes)
+
+	router := timeSliceRouter{
+		weights: weights,
+		routes:  routes,
+		period:  period,
+		now:     time.Now,
+		account: NewAccount(),
+		cycle:   -1,
+	}
+
+	phaseLocker.Lock()
+	router.phase = time.Duration(phaseRand.Int63n(int64(period)))
+	phaseLocker.Unlock()
+
+	return &router
+}
@@ -0,0 +1,173 @@
+package rule
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"sync"
+)
+
+const NameDefault = "default"
+
+// Rule sets the fee of the miners it matches, empty fields match everything
+type Rule struct {
+	Name string `json:"name"`
+	// Wallet and Worker are shell patterns, like 0xabc* or rig-*
+	Wallet string `json:"wallet"`
+	Worker string `json:"worker"`
+	// CIDR matches the source address of the miner
+	CIDR string `json:"cidr"`
+	// Listener is the address of the listener the miner connected to, like 0.0.0.0:4444
+	Listener string  `json:"listener"`
+	Weight   float64 `json:"weight"`
+
+	network *net.IPNet
+}
+
+// Miner is what a rule is matched against, known once the miner authorized
+type Miner struct {
+	Wallet   string
+	Worker   string
+	Address  net.Addr
+	Listener string
+}
+
+func (r *Rule) compile() error {
+	if r.Weight < 0 || r.Weight > 1 {
+		return fmt.Errorf("weight %f of rule %s is out of range", r.Weight, r.Name)
+	}
+
+	for _, pattern := range []string{r.Wallet, r.Worker} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+	}
+
+	if r.CIDR != "" {
+		_, network, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+
+		r.network = network
+	}
+
+	return nil
+}
+
+func (r *Rule) match(miner Miner) bool {
+	if !matchPattern(r.Wallet, miner.Wallet) || !matchPattern(r.Worker, miner.Worker) {
+		return false
+	}
+
+	if r.Listener != "" && r.Listener != miner.Listener {
+		return false
+	}
+
+	if r.network != nil {
+		if miner.Address == nil {
+			return false
+		}
+
+		host, _, err := net.SplitHostPort(miner.Address.String())
+		if err != nil {
+			return false
+		}
+
+		if ip := net.ParseIP(host); ip == nil || !r.network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchPattern(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	matched, _ := path.Match(pattern, value)
+
+	return matched
+}
+
+// Set is the rules in order and the default rule, it can be replaced while sessions are matching it
+type Set struct {
+	rules  []Rule
+	def    Rule
+	locker sync.RWMutex
+}
+
+// Match returns the first rule matching the miner, the default rule if none does
+func (s *Set) Match(miner Miner) Rule {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	for _, rule := range s.rules {
+		if rule.match(miner) {
+			return rule
+		}
+	}
+
+	return s.def
+}
+
+// Rules returns a copy of the rules followed by the default rule
+func (s *Set) Rules() []Rule {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	return append(append([]Rule(nil), s.rules...), s.def)
+}
+
+// Replace swaps the rules, the default rule is kept, sessions that already authorized keep their rule
+func (s *Set) Replace(rules []Rule) error {
+	compiled := make([]Rule, 0, len(rules))
+
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return err
+		}
+
+		compiled = append(compiled, rule)
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.rules = compiled
+
+	return nil
+}
+
+// ReplaceJSON swaps the rules with a JSON list of them
+func (s *Set) ReplaceJSON(data []byte) error {
+	rules := make([]Rule, 0)
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+
+	return s.Replace(rules)
+}
+
+// NewSet returns the rules with a default rule of the weight for everyone else
+func NewSet(rules []Rule, weight float64) (*Set, error) {
+	set := Set{
+		def: Rule{
+			Name:   NameDefault,
+			Weight: weight,
+		},
+	}
+
+	if err := set.def.compile(); err != nil {
+		return nil, err
+	}
+
+	if err := set.Replace(rules); err != nil {
+		return nil, err
+	}
+
+	return &set, nil
+}
@@ -0,0 +1,139 @@
+package rule
+
+import (
+	"net"
+	"testing"
+)
+
+func addr(address string) net.Addr {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		panic(err)
+	}
+
+	return tcpAddr
+}
+
+func TestMatch(t *testing.T) {
+	miner := Miner{
+		Wallet:   "0xabc123",
+		Worker:   "rig-01",
+		Address:  addr("10.1.2.3:40000"),
+		Listener: "0.0.0.0:4444",
+	}
+
+	tests := []struct {
+		name    string
+		rule    Rule
+		miner   Miner
+		matched bool
+	}{
+		{"empty", Rule{}, miner, true},
+		{"wallet", Rule{Wallet: "0xabc123"}, miner, true},
+		{"wallet pattern", Rule{Wallet: "0xabc*"}, miner, true},
+		{"other wallet", Rule{Wallet: "0xdef*"}, miner, false},
+		{"worker pattern", Rule{Worker: "rig-??"}, miner, true},
+		{"other worker", Rule{Worker: "gpu-*"}, miner, false},
+		{"cidr", Rule{CIDR: "10.0.0.0/8"}, miner, true},
+		{"other cidr", Rule{CIDR: "192.168.0.0/16"}, miner, false},
+		{"ipv6 cidr", Rule{CIDR: "fd00::/8"}, Miner{Address: addr("[fd00::1]:40000")}, true},
+		{"cidr without address", Rule{CIDR: "10.0.0.0/8"}, Miner{Wallet: "0xabc123"}, false},
+		{"listener", Rule{Listener: "0.0.0.0:4444"}, miner, true},
+		{"other listener", Rule{Listener: "0.0.0.0:8080"}, miner, false},
+		{"all fields", Rule{Wallet: "0xabc*", Worker: "rig-*", CIDR: "10.1.0.0/16", Listener: "0.0.0.0:4444"}, miner, true},
+		{"one field differs", Rule{Wallet: "0xabc*", Worker: "rig-*", CIDR: "10.2.0.0/16"}, miner, false},
+	}
+
+	for _, test := range tests {
+		rule := test.rule
+		if err := rule.compile(); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		if matched := rule.match(test.miner); matched != test.matched {
+			t.Errorf("%s: matched %t, want %t", test.name, matched, test.matched)
+		}
+	}
+}
+
+func TestMatchOrder(t *testing.T) {
+	set, err := NewSet([]Rule{
+		{Name: "internal", CIDR: "10.0.0.0/8", Weight: 0},
+		{Name: "partner", Wallet: "0xabc*", Weight: 0.005},
+		{Name: "everyone", Weight: 0.02},
+	}, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		miner Miner
+		rule  string
+	}{
+		// The first matching rule wins even if later ones match too
+		{Miner{Wallet: "0xabc123", Address: addr("10.0.0.1:1")}, "internal"},
+		{Miner{Wallet: "0xabc123", Address: addr("203.0.113.1:1")}, "partner"},
+		{Miner{Wallet: "0xdef456"}, "everyone"},
+	}
+
+	for _, test := range tests {
+		if rule := set.Match(test.miner); rule.Name != test.rule {
+			t.Errorf("%+v matched %s, want %s", test.miner, rule.Name, test.rule)
+		}
+	}
+
+	// Without a catch-all rule the default applies
+	if err := set.Replace([]Rule{{Name: "partner", Wallet: "0xabc*", Weight: 0.005}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rule := set.Match(Miner{Wallet: "0xdef456"}); rule.Name != NameDefault || rule.Weight != 0.01 {
+		t.Errorf("matched %+v, want the default rule", rule)
+	}
+
+	if rules := set.Rules(); len(rules) != 2 || rules[1].Name != NameDefault {
+		t.Errorf("rules %+v don't end with the default rule", rules)
+	}
+}
+
+func TestReplaceJSON(t *testing.T) {
+	set, err := NewSet([]Rule{{Name: "partner", Wallet: "0xabc*", Weight: 0.005}}, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []struct {
+		name string
+		data string
+	}{
+		{"malformed json", `[{"name":`},
+		{"invalid wallet pattern", `[{"name":"broken","wallet":"0x[abc","weight":0.01}]`},
+		{"invalid worker pattern", `[{"name":"broken","worker":"rig-[","weight":0.01}]`},
+		{"invalid cidr", `[{"name":"broken","cidr":"10.0.0.0/33","weight":0.01}]`},
+		{"negative weight", `[{"name":"broken","weight":-0.1}]`},
+		{"weight above 1", `[{"name":"broken","weight":1.5}]`},
+	}
+
+	for _, test := range invalid {
+		if err := set.ReplaceJSON([]byte(test.data)); err == nil {
+			t.Errorf("%s was accepted", test.name)
+		}
+	}
+
+	// A rejected list keeps the previous rules
+	if rule := set.Match(Miner{Wallet: "0xabc123"}); rule.Name != "partner" {
+		t.Errorf("matched %s after invalid lists, want partner", rule.Name)
+	}
+
+	if err := set.ReplaceJSON([]byte(`[{"name":"internal","cidr":"10.0.0.0/8","weight":0}]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if rule := set.Match(Miner{Wallet: "0xabc123", Address: addr("10.0.0.1:1")}); rule.Name != "internal" {
+		t.Errorf("matched %s, want internal", rule.Name)
+	}
+
+	if rule := set.Match(Miner{Wallet: "0xabc123"}); rule.Name != NameDefault {
+		t.Errorf("matched %s, want the default rule", rule.Name)
+	}
+}
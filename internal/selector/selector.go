@@ -0,0 +1,235 @@
+package selector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+	"github.com/tier2pool/tier2pool/internal/metrics"
+)
+
+const (
+	DefaultInterval = time.Second * 30
+
+	// A pool that doesn't answer the probe request in time is unhealthy
+	probeTimeout = time.Second * 10
+
+	// Weight of the newest sample in the moving average
+	smoothing = 0.3
+)
+
+type Endpoint struct {
+	URL            string
+	ConnectLatency time.Duration
+	ShareLatency   time.Duration
+	Healthy        bool
+}
+
+// score prefers the share latency, which includes the pool's own processing time,
+// and falls back to the connect latency until a share has been answered
+func (e *Endpoint) score() time.Duration {
+	if e.ShareLatency > 0 {
+		return e.ShareLatency
+	}
+
+	return e.ConnectLatency
+}
+
+// Probe is the request a pool must answer to be healthy, a response carrying an error counts as an answer
+type Probe struct {
+	Method string
+	Params interface{}
+}
+
+// Selector assigns new sessions to the lowest latency healthy endpoint
+type Selector struct {
+	dialer    *jsonrpc.Dialer
+	request   *Probe
+	interval  time.Duration
+	endpoints []*Endpoint
+	locker    sync.RWMutex
+}
+
+// Select returns the URL of the best endpoint, the first one if none is known to be healthy
+func (s *Selector) Select() string {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	var best *Endpoint
+
+	for _, endpoint := range s.endpoints {
+		if !endpoint.Healthy {
+			continue
+		}
+
+		if best == nil || endpoint.score() < best.score() {
+			best = endpoint
+		}
+	}
+
+	if best == nil {
+		best = s.endpoints[0]
+	}
+
+	metrics.PoolSessions.WithLabelValues(best.URL).Inc()
+
+	return best.URL
+}
+
+// ObserveShare records the time a pool took to answer a share
+func (s *Selector) ObserveShare(url string, latency time.Duration) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	for _, endpoint := range s.endpoints {
+		if endpoint.URL == url {
+			endpoint.ShareLatency = smooth(endpoint.ShareLatency, latency)
+
+			metrics.PoolShareLatency.WithLabelValues(url).Set(endpoint.ShareLatency.Seconds())
+
+			return
+		}
+	}
+}
+
+// Endpoints returns a snapshot of all measurements
+func (s *Selector) Endpoints() []Endpoint {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	endpoints := make([]Endpoint, 0, len(s.endpoints))
+
+	for _, endpoint := range s.endpoints {
+		endpoints = append(endpoints, *endpoint)
+	}
+
+	return endpoints
+}
+
+// Run probes all endpoints until the context is done
+func (s *Selector) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.probe(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Selector) probe(ctx context.Context) {
+	wg := sync.WaitGroup{}
+
+	for _, endpoint := range s.Endpoints() {
+		wg.Add(1)
+
+		go func(url string) {
+			defer wg.Done()
+
+			startedAt := time.Now()
+
+			conn, err := s.dialer.DialContext(ctx, url)
+			if err == nil {
+				err = s.call(ctx, conn)
+
+				_ = conn.Close()
+			}
+
+			s.observeConnect(url, time.Since(startedAt), err)
+		}(endpoint.URL)
+	}
+
+	wg.Wait()
+}
+
+// call sends the probe request, so that a pool accepting connections without answering is unhealthy
+func (s *Selector) call(ctx context.Context, conn jsonrpc.Conn) error {
+	if s.request == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	client := jsonrpc.NewClient(conn, nil, 0)
+	defer client.Close()
+
+	var rpcError *jsonrpc.Error
+
+	if _, err := client.Call(ctx, s.request.Method, s.request.Params); err != nil && !errors.As(err, &rpcError) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Selector) observeConnect(url string, latency time.Duration, err error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	for _, endpoint := range s.endpoints {
+		if endpoint.URL != url {
+			continue
+		}
+
+		if err != nil {
+			if endpoint.Healthy {
+				logrus.Warnf("pool %s is unhealthy: %s", url, err)
+			}
+
+			endpoint.Healthy = false
+
+			metrics.PoolHealthy.WithLabelValues(url).Set(0)
+
+			return
+		}
+
+		endpoint.Healthy = true
+		endpoint.ConnectLatency = smooth(endpoint.ConnectLatency, latency)
+
+		metrics.PoolHealthy.WithLabelValues(url).Set(1)
+		metrics.PoolConnectLatency.WithLabelValues(url).Set(endpoint.ConnectLatency.Seconds())
+
+		return
+	}
+}
+
+func smooth(average, sample time.Duration) time.Duration {
+	if average == 0 {
+		return sample
+	}
+
+	return time.Duration(float64(sample)*smoothing + float64(average)*(1-smoothing))
+}
+
+// New returns a selector of the URLs, endpoints are considered healthy until the first probe fails,
+// a nil probe only checks that the pool accepts connections
+func New(dialer *jsonrpc.Dialer, urls []string, interval time.Duration, probe *Probe) *Selector {
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+
+	selector := Selector{
+		dialer:    dialer,
+		request:   probe,
+		interval:  interval,
+		endpoints: make([]*Endpoint, 0, len(urls)),
+	}
+
+	for _, url := range urls {
+		selector.endpoints = append(selector.endpoints, &Endpoint{
+			URL:     url,
+			Healthy: true,
+		})
+	}
+
+	return &selector
+}
@@ -0,0 +1,96 @@
+package selector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tier2pool/tier2pool/internal/jsonrpc"
+)
+
+// fakePool accepts connections and answers requests with the reply, an empty reply never answers
+func fakePool(t *testing.T, reply string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					request := jsonrpc.Request{}
+					if err := json.Unmarshal(scanner.Bytes(), &request); err != nil || reply == "" {
+						continue
+					}
+
+					_, _ = fmt.Fprintf(conn, reply+"\n", request.ID)
+				}
+			}()
+		}
+	}()
+
+	return "tcp://" + listener.Addr().String()
+}
+
+func TestProbe(t *testing.T) {
+	answering := fakePool(t, `{"id":%s,"result":[["mining.notify","1","EthereumStratum/1.0.0"],"00"],"error":null}`)
+	refusing := fakePool(t, `{"id":%s,"result":null,"error":[20,"Other/Unknown",null]}`)
+	silent := fakePool(t, "")
+
+	selector := New(nil, []string{silent, answering, refusing}, time.Second, &Probe{
+		Method: "mining.subscribe",
+		Params: []string{"tier2pool", "EthereumStratum/1.0.0"},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+
+	selector.probe(ctx)
+
+	healthy := map[string]bool{}
+	for _, endpoint := range selector.Endpoints() {
+		healthy[endpoint.URL] = endpoint.Healthy
+	}
+
+	if !healthy[answering] || !healthy[refusing] {
+		t.Errorf("answering pools are unhealthy: %v", healthy)
+	}
+
+	if healthy[silent] {
+		t.Error("the silent pool is healthy")
+	}
+
+	if url := selector.Select(); url == silent {
+		t.Errorf("the silent pool %s is selected", url)
+	}
+}
+
+func TestProbeDialOnly(t *testing.T) {
+	silent := fakePool(t, "")
+
+	selector := New(nil, []string{silent}, time.Second, nil)
+	selector.probe(context.Background())
+
+	if endpoints := selector.Endpoints(); !endpoints[0].Healthy || endpoints[0].ConnectLatency == 0 {
+		t.Errorf("the reachable pool is unhealthy: %+v", endpoints[0])
+	}
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	// Shards spread the locking of concurrent sessions
+	memoryShards = 64
+
+	// Expired entries of a shard are swept at most this often, they're never returned in between
+	memorySweepInterval = time.Minute
+)
+
+var _ JobStore = &MemoryJobStore{}
+
+type memoryEntry struct {
+	route     string
+	expiresAt time.Time
+}
+
+type memoryShard struct {
+	entries map[string]memoryEntry
+	sweptAt time.Time
+	locker  sync.RWMutex
+}
+
+// sweep drops the expired entries, the caller must hold the write lock
+func (s *memoryShard) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < memorySweepInterval {
+		return
+	}
+
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+
+	s.sweptAt = now
+}
+
+func (s *memoryShard) get(key string, now time.Time) string {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		return ""
+	}
+
+	return entry.route
+}
+
+// MemoryJobStore keeps the jobs in the process, it's only shared by the sessions of one instance
+type MemoryJobStore struct {
+	shards [memoryShards]*memoryShard
+	now    func() time.Time
+}
+
+func (m *MemoryJobStore) shard(key string) *memoryShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+
+	return m.shards[hash.Sum32()%memoryShards]
+}
+
+func (m *MemoryJobStore) Set(_ context.Context, key string, route string, ttl time.Duration) error {
+	shard := m.shard(key)
+	now := m.now()
+
+	shard.locker.Lock()
+	defer shard.locker.Unlock()
+
+	shard.sweep(now)
+
+	shard.entries[key] = memoryEntry{
+		route:     route,
+		expiresAt: now.Add(ttl),
+	}
+
+	return nil
+}
+
+func (m *MemoryJobStore) Get(_ context.Context, keys ...string) (string, error) {
+	now := m.now()
+
+	for _, key := range keys {
+		if route := m.shard(key).get(key, now); route != "" {
+			return route, nil
+		}
+	}
+
+	return "", nil
+}
+
+func NewMemoryJobStore() *MemoryJobStore {
+	store := MemoryJobStore{
+		now: time.Now,
+	}
+
+	for i := range store.shards {
+		store.shards[i] = &memoryShard{
+			entries: make(map[string]memoryEntry),
+		}
+	}
+
+	return &store
+}
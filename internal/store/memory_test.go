@@ -0,0 +1,182 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clock is a fake time source of the memory store
+type clock struct {
+	now    time.Time
+	locker sync.Mutex
+}
+
+func (c *clock) Now() time.Time {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func newTestMemoryJobStore() (*MemoryJobStore, *clock) {
+	c := clock{now: time.Unix(0, 0)}
+
+	store := NewMemoryJobStore()
+	store.now = c.Now
+
+	return store, &c
+}
+
+func TestMemoryJobStore(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestMemoryJobStore()
+
+	if err := store.Set(ctx, "a", "inject", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Set(ctx, "b", "develop", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		keys  []string
+		route string
+	}{
+		{[]string{"a"}, "inject"},
+		{[]string{"missing", "b"}, "develop"},
+		{[]string{"a", "b"}, "inject"},
+		{[]string{"missing"}, ""},
+		{nil, ""},
+	}
+
+	for _, test := range tests {
+		route, err := store.Get(ctx, test.keys...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if route != test.route {
+			t.Errorf("%v: expected %q, got %q", test.keys, test.route, route)
+		}
+	}
+}
+
+func TestMemoryJobStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	store, c := newTestMemoryJobStore()
+
+	if err := store.Set(ctx, "job", "inject", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Add(time.Minute - time.Second)
+
+	if route, _ := store.Get(ctx, "job"); route != "inject" {
+		t.Errorf("the job expired before its TTL, got %q", route)
+	}
+
+	// Expired entries are never returned, even before they're swept
+	c.Add(time.Second * 2)
+
+	if route, _ := store.Get(ctx, "job"); route != "" {
+		t.Errorf("the job outlived its TTL, got %q", route)
+	}
+
+	// Setting again renews the job
+	if err := store.Set(ctx, "job", "develop", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if route, _ := store.Get(ctx, "job"); route != "develop" {
+		t.Errorf("expected the renewed job, got %q", route)
+	}
+}
+
+func TestMemoryJobStoreSweep(t *testing.T) {
+	ctx := context.Background()
+	store, c := newTestMemoryJobStore()
+
+	for i := 0; i < 1000; i++ {
+		if err := store.Set(ctx, fmt.Sprint("old-", i), "inject", time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every shard is swept on its next write once the interval passed
+	c.Add(memorySweepInterval + time.Second)
+
+	for i := 0; i < 10000; i++ {
+		if err := store.Set(ctx, fmt.Sprint("new-", i), "inject", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := 0
+
+	for _, shard := range store.shards {
+		shard.locker.RLock()
+
+		for key := range shard.entries {
+			if key[:4] == "old-" {
+				entries++
+			}
+		}
+
+		shard.locker.RUnlock()
+	}
+
+	if entries > 0 {
+		t.Errorf("%d expired entries weren't swept", entries)
+	}
+}
+
+func TestMemoryJobStoreConcurrent(t *testing.T) {
+	ctx := context.Background()
+	store, c := newTestMemoryJobStore()
+
+	wg := sync.WaitGroup{}
+
+	for session := 0; session < 16; session++ {
+		wg.Add(1)
+
+		go func(session int) {
+			defer wg.Done()
+
+			route := fmt.Sprint("route-", session)
+
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("%d:%d", session, i)
+
+				if err := store.Set(ctx, key, route, time.Hour); err != nil {
+					t.Error(err)
+
+					return
+				}
+
+				if found, err := store.Get(ctx, key); err != nil || found != route {
+					t.Errorf("%s: expected %s, got %q (%v)", key, route, found, err)
+
+					return
+				}
+
+				// Sweeps run while other sessions read and write
+				if i%100 == 0 {
+					c.Add(memorySweepInterval)
+				}
+			}
+		}(session)
+	}
+
+	wg.Wait()
+}
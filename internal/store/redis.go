@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var _ JobStore = &RedisJobStore{}
+
+// RedisJobStore shares the jobs between instances behind a load balancer,
+// the client can be a single node, a Sentinel failover or a Cluster client
+type RedisJobStore struct {
+	client redis.UniversalClient
+}
+
+func (r *RedisJobStore) Set(ctx context.Context, key string, route string, ttl time.Duration) error {
+	return r.client.Set(ctx, key, route, ttl).Err()
+}
+
+// Get looks all keys up in one round trip
+func (r *RedisJobStore) Get(ctx context.Context, keys ...string) (string, error) {
+	if len(keys) == 0 {
+		return "", nil
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return "", err
+	}
+
+	for _, value := range values {
+		if route, ok := value.(string); ok && route != "" {
+			return route, nil
+		}
+	}
+
+	return "", nil
+}
+
+func NewRedisJobStore(client redis.UniversalClient) *RedisJobStore {
+	return &RedisJobStore{
+		client: client,
+	}
+}
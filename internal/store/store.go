@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	NameMemory = "memory"
+	NameRedis  = "redis"
+
+	DefaultPrefix = "tier2pool:"
+)
+
+// JobStore remembers which route a job was sent for, so that a share found on it reaches the right pool
+type JobStore interface {
+	// Set stores the route of the job until the TTL expires
+	Set(ctx context.Context, key string, route string, ttl time.Duration) error
+	// Get returns the route of the first key found, empty if none is known or all of them expired
+	Get(ctx context.Context, keys ...string) (string, error)
+}
+
+// Namespace scopes the keys of a session, so that neither sessions nor the upstreams of a session
+// can see each other's jobs, and nothing else in a shared Redis can collide with them
+type Namespace struct {
+	Prefix  string
+	Token   string
+	Session string
+}
+
+// Key returns the key of a job of the upstream, the session is a hash tag to keep the keys of a session
+// in the same Redis Cluster slot
+func (n Namespace) Key(upstream, id string) string {
+	prefix := n.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	return prefix + n.Token + ":{" + n.Session + "}:" + upstream + ":" + id
+}
+
+// NewSession returns a random session id
+func NewSession() (string, error) {
+	session := make([]byte, 8)
+	if _, err := rand.Read(session); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(session), nil
+}
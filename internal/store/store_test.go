@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNamespaceKey(t *testing.T) {
+	namespace := Namespace{Token: "ETH", Session: "0011"}
+
+	if key := namespace.Key("inject", "0xjob"); key != "tier2pool:ETH:{0011}:inject:0xjob" {
+		t.Errorf("unexpected key %s", key)
+	}
+
+	namespace.Prefix = "tunnel-a:"
+
+	if key := namespace.Key("inject", "0xjob"); !strings.HasPrefix(key, "tunnel-a:ETH:") {
+		t.Errorf("the prefix isn't used, got %s", key)
+	}
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryJobStore()
+
+	base := Namespace{Token: "ETH", Session: "a"}
+
+	others := map[string]Namespace{
+		"session":  {Token: "ETH", Session: "b"},
+		"token":    {Token: "ETC", Session: "a"},
+		"instance": {Prefix: "other:", Token: "ETH", Session: "a"},
+	}
+
+	if err := store.Set(ctx, base.Key("inject", "0xjob"), "inject", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, namespace := range others {
+		route, err := store.Get(ctx, namespace.Key("inject", "0xjob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if route != "" {
+			t.Errorf("another %s sees the job of the session", name)
+		}
+	}
+
+	// The upstreams of a session don't see each other's jobs either
+	if route, _ := store.Get(ctx, base.Key("develop", "0xjob")); route != "" {
+		t.Error("another upstream sees the job")
+	}
+
+	if route, _ := store.Get(ctx, base.Key("develop", "0xjob"), base.Key("inject", "0xjob")); route != "inject" {
+		t.Errorf("expected inject, got %q", route)
+	}
+}
+
+func TestNewSession(t *testing.T) {
+	sessions := make(map[string]bool)
+
+	for i := 0; i < 100; i++ {
+		session, err := NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(session) != 16 || sessions[session] {
+			t.Fatalf("session %s is malformed or repeated", session)
+		}
+
+		sessions[session] = true
+	}
+}
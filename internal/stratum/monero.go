@@ -0,0 +1,12 @@
+package stratum
+
+// https://github.com/xmrig/xmrig-proxy/blob/master/doc/STRATUM.md
+
+const (
+	MethodMoneroLogin = "login"
+)
+
+type MoneroLoginParams struct {
+	Login string `json:"login"`
+	Pass  string `json:"pass"`
+}
@@ -7,10 +7,27 @@ const (
 	MethodNiceHashNotify    = "mining.notify"
 	MethodNiceHashSubmit    = "mining.submit"
 	MethodNiceHashAuthorize = "mining.authorize"
+
+	MethodNiceHashExtranonceSubscribe = "mining.extranonce.subscribe"
+
+	MethodNiceHashSetDifficulty = "mining.set_difficulty"
+	MethodNiceHashSetExtranonce = "mining.set_extranonce"
+
+	MethodClientShowMessage = "client.show_message"
+
+	NiceHashProtocol = "EthereumStratum/1.0.0"
 )
 
+type NiceHashSubscribeParams []string
+
 type NiceHashAuthorizeParams []string
 
 type NiceHashNotifyParams []any
 
 type NiceHashSubmitParams []string
+
+type NiceHashSetDifficultyParams []float64
+
+type NiceHashSubscribeResult []any
+
+type ClientShowMessageParams []string
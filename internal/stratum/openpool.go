@@ -1,3 +1,9 @@
 package stratum
 
 // https://github.com/sammy007/open-ethereum-pool/blob/master/docs/STRATUM.md
+
+const (
+	MethodOpenPoolSubmitLogin = "eth_submitLogin"
+)
+
+type OpenPoolSubmitLoginParams []string